@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/entitlements"
+	"github.com/google/uuid"
+)
+
+// userLimits returns the plan a user is on and what it allows. Only a
+// subscription that is currently entitled counts; anything else is the
+// free plan.
+func (cfg *apiConfig) userLimits(ctx context.Context, userID uuid.UUID) (string, entitlements.Limits, error) {
+	sub, err := cfg.loadSubscription(ctx, userID)
+	if err != nil {
+		return "", entitlements.Limits{}, err
+	}
+	if !sub.Entitled(time.Now()) {
+		return entitlements.FreePlan, cfg.plans.For(entitlements.FreePlan), nil
+	}
+	return sub.Plan, cfg.plans.For(sub.Plan), nil
+}
+
+func (cfg *apiConfig) handlerEntitlements(w http.ResponseWriter, req *http.Request) {
+	type response struct {
+		Plan string `json:"plan"`
+		entitlements.Limits
+	}
+
+	user, _ := authUserFromContext(req.Context())
+	plan, limits, err := cfg.userLimits(req.Context(), user.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching plan failed", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{Plan: plan, Limits: limits})
+}
+
+// chirpQuotaWait returns how long the user has to wait before their plan's
+// hourly chirp quota allows another chirp, or 0 if it allows one now. Call
+// it in the transaction that creates the chirp after q.LockUser, so that
+// concurrent requests can't both take the last chirp of the hour.
+func chirpQuotaWait(ctx context.Context, q *database.Queries, userID uuid.UUID, limits entitlements.Limits) (time.Duration, error) {
+	if limits.ChirpsPerHour == 0 {
+		return 0, nil
+	}
+	activity, err := q.GetRecentChirpActivity(ctx, database.GetRecentChirpActivityParams{
+		UserID:    userID,
+		CreatedAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if activity.ChirpCount < int64(limits.ChirpsPerHour) {
+		return 0, nil
+	}
+	return max(time.Until(activity.Oldest.Add(time.Hour)), time.Second), nil
+}
+
+func respondChirpRateLimited(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+	respondWithError(w, http.StatusTooManyRequests, "hourly chirp limit reached for your plan")
+}
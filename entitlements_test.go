@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/entitlements"
+	"github.com/chichigami/chirpy/internal/subscription"
+	"github.com/google/uuid"
+)
+
+// onPlan makes userID's subscription entitle them to red, or leaves them
+// on the free plan.
+func onPlan(db *fakeDB, userID uuid.UUID, red bool) {
+	if !red {
+		db.returns("GetSubscription")
+		return
+	}
+	db.returns("GetSubscription", subscriptionRow(userID, subscription.StatusActive, time.Now().Add(time.Hour)))
+}
+
+func TestHandlerChirpsUpdatePlanGating(t *testing.T) {
+	tests := []struct {
+		name       string
+		red        bool
+		age        time.Duration
+		wantStatus int
+		wantError  string
+	}{
+		{"free plan", false, time.Minute, 403, "your plan does not include editing chirps"},
+		{"red plan inside the edit window", true, time.Minute, 200, ""},
+		{"red plan after the edit window", true, 20 * time.Minute, 403, "the edit window for this chirp has passed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := uuid.New()
+			chirpID := uuid.New()
+			createdAt := time.Now().Add(-tt.age)
+			db := newFakeDB(t)
+			onPlan(db, userID, tt.red)
+			db.returns("GetChirp", []any{chirpID, createdAt, createdAt, "hello", userID})
+			db.returns("UpdateChirpBody", []any{chirpID, createdAt, time.Now(), "edited", userID})
+			cfg := db.config()
+			cfg.plans = entitlements.Default
+
+			req := httptest.NewRequest(http.MethodPut, "/api/chirps/"+chirpID.String(), strings.NewReader(`{"body":"edited"}`))
+			req.SetPathValue("chirpID", chirpID.String())
+			req.Header.Set("Authorization", "Bearer "+testToken(t, userID, "", ""))
+			resp := httptest.NewRecorder()
+			cfg.middlewareAuth(cfg.handlerChirpsUpdate)(resp, req)
+
+			if resp.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", resp.Code, tt.wantStatus, resp.Body)
+			}
+			if tt.wantError != "" && !strings.Contains(resp.Body.String(), tt.wantError) {
+				t.Errorf("body = %s, want error %q", resp.Body, tt.wantError)
+			}
+			if edited := len(db.calledWith("UpdateChirpBody")) > 0; edited != (tt.wantStatus == 200) {
+				t.Errorf("chirp edited: %v", edited)
+			}
+		})
+	}
+}
+
+func TestHandlerChirpsCreateTooLong(t *testing.T) {
+	userID := uuid.New()
+	db := newFakeDB(t)
+	onPlan(db, userID, false)
+	cfg := db.config()
+	cfg.plans = entitlements.Default
+
+	body := `{"body":"` + strings.Repeat("a", entitlements.Default[entitlements.FreePlan].MaxChirpLength+1) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken(t, userID, "", ""))
+	resp := httptest.NewRecorder()
+	cfg.middlewareAuth(cfg.handlerChirpsCreate)(resp, req)
+
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "chirp is too long") {
+		t.Errorf("status = %d, body = %s, want 400 chirp is too long", resp.Code, resp.Body)
+	}
+	if got := db.callNames(); !reflect.DeepEqual(got, []string{"GetSubscription"}) {
+		t.Errorf("statements = %v, want only the plan lookup", got)
+	}
+}
+
+func TestHandlerChirpsCreateRateLimited(t *testing.T) {
+	userID := uuid.New()
+	db := newFakeDB(t)
+	onPlan(db, userID, false)
+	db.returns("LockUser")
+	quota := entitlements.Default[entitlements.FreePlan].ChirpsPerHour
+	db.returns("GetRecentChirpActivity", []any{int64(quota), time.Now().Add(-30 * time.Minute)})
+	cfg := db.config()
+	cfg.plans = entitlements.Default
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"hello"}`))
+	req.Header.Set("Authorization", "Bearer "+testToken(t, userID, "", ""))
+	resp := httptest.NewRecorder()
+	cfg.middlewareAuth(cfg.handlerChirpsCreate)(resp, req)
+
+	if resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429 (%s)", resp.Code, resp.Body)
+	}
+	retryAfter, err := strconv.Atoi(resp.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 29*60 || retryAfter > 30*60 {
+		t.Errorf("Retry-After = %q, want about 30 minutes", resp.Header().Get("Retry-After"))
+	}
+	// the count has to happen under the lock, in the transaction that
+	// would insert the chirp
+	want := []string{"GetSubscription", "BEGIN", "LockUser", "GetRecentChirpActivity", "COMMIT"}
+	if got := db.callNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("statements = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/tracing"
+)
+
+// fakeDB is a database/sql driver for handler tests. Each statement is
+// answered by the function registered under its sqlc query name; anything
+// unregistered fails the test. Transactions are recorded as BEGIN, COMMIT
+// and ROLLBACK calls so tests can check what ran inside one.
+type fakeDB struct {
+	t       *testing.T
+	mu      sync.Mutex
+	queries map[string]fakeQuery
+	calls   []fakeCall
+	pingErr error
+}
+
+// fakeQuery answers one statement. rows is what a query returns; affected is
+// what an exec reports.
+type fakeQuery func(args []driver.Value) (rows [][]any, affected int64, err error)
+
+type fakeCall struct {
+	name string
+	args []driver.Value
+}
+
+func newFakeDB(t *testing.T) *fakeDB {
+	return &fakeDB{t: t, queries: map[string]fakeQuery{}}
+}
+
+func (f *fakeDB) on(name string, query fakeQuery) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries[name] = query
+}
+
+// returns answers name with rows on every call, reporting one affected row
+// per returned row.
+func (f *fakeDB) returns(name string, rows ...[]any) {
+	f.on(name, func([]driver.Value) ([][]any, int64, error) {
+		return rows, int64(len(rows)), nil
+	})
+}
+
+// calledWith returns the arguments of every call to name.
+func (f *fakeDB) calledWith(name string) [][]driver.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var args [][]driver.Value
+	for _, call := range f.calls {
+		if call.name == name {
+			args = append(args, call.args)
+		}
+	}
+	return args
+}
+
+// callNames returns the name of every statement run so far, in order.
+func (f *fakeDB) callNames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, len(f.calls))
+	for i, call := range f.calls {
+		names[i] = call.name
+	}
+	return names
+}
+
+// config returns an apiConfig whose database is f.
+func (f *fakeDB) config() *apiConfig {
+	sqlDB := sql.OpenDB(f)
+	f.t.Cleanup(func() { sqlDB.Close() })
+	return &apiConfig{
+		jwtSecret: testJWTSecret,
+		db:        database.New(sqlDB),
+		sqlDB:     sqlDB,
+	}
+}
+
+func (f *fakeDB) run(query string, args []driver.NamedValue) ([][]any, int64, error) {
+	name := tracing.QueryName(query)
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, fakeCall{name: name, args: values})
+	answer, ok := f.queries[name]
+	f.mu.Unlock()
+	if !ok {
+		f.t.Errorf("unexpected query %s", name)
+		return nil, 0, fmt.Errorf("fakedb: unexpected query %s", name)
+	}
+	return answer(values)
+}
+
+func (f *fakeDB) record(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, fakeCall{name: name})
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakedb: use sql.OpenDB")
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fakedb: prepared statements are not supported")
+}
+
+func (c fakeConn) Close() error { return nil }
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.db.record("BEGIN")
+	return fakeTx{c.db}, nil
+}
+
+func (c fakeConn) Ping(context.Context) error { return c.db.pingErr }
+
+// CheckNamedValue passes arguments through as their driver values, so tests
+// see uuids as strings and nullable types as their value or nil.
+func (c fakeConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if valuer, ok := nv.Value.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		nv.Value = value
+		return err
+	}
+	return nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, affected, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, _, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error {
+	tx.db.record("COMMIT")
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.record("ROLLBACK")
+	return nil
+}
+
+type fakeRows struct {
+	rows [][]any
+	next int
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next == len(r.rows) {
+		return io.EOF
+	}
+	for i, value := range r.rows[r.next] {
+		if valuer, ok := value.(driver.Valuer); ok {
+			var err error
+			if value, err = valuer.Value(); err != nil {
+				return err
+			}
+		}
+		dest[i] = value
+	}
+	r.next++
+	return nil
+}
+
+// userRow is u as the columns the users queries select.
+func userRow(u database.User) []any {
+	return []any{
+		u.ID, u.CreatedAt, u.UpdatedAt, u.Email, u.HashedPassword, u.IsChirpyRed,
+		u.EmailVerifiedAt, u.TotpSecret, u.TotpEnabledAt, u.DeletionRequestedAt, u.Role,
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+// accountDeletionGracePeriod is how long a deleted account can still be
+// restored by logging in before it is removed for good.
+const accountDeletionGracePeriod = 30 * 24 * time.Hour
+
+func (cfg *apiConfig) handlerUsersDelete(w http.ResponseWriter, req *http.Request) {
+	type parameter struct {
+		Password string `json:"password"`
+	}
+	type response struct {
+		DeleteAfter time.Time `json:"delete_after"`
+	}
+
+	user, _ := authUserFromContext(req.Context())
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(req.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 404, "user cannot be found")
+		return
+	}
+	if !cfg.reauthenticate(w, req, dbUser, param.Password) {
+		return
+	}
+
+	requestedAt, err := cfg.db.RequestUserDeletion(req.Context(), dbUser.ID)
+	if err != nil {
+		respondWithServerError(w, "scheduling account deletion failed", err)
+		return
+	}
+	if err := cfg.db.RevokeAllRefreshTokensForUser(req.Context(), dbUser.ID); err != nil {
+		respondWithServerError(w, "revoking refresh tokens failed", err)
+		return
+	}
+
+	deleteAfter := requestedAt.Time.Add(accountDeletionGracePeriod)
+	err = cfg.mailer.Send(req.Context(), mailer.Message{
+		To:      dbUser.Email,
+		Subject: "Your Chirpy account will be deleted",
+		Body:    fmt.Sprintf("Your Chirpy account and all of its chirps will be deleted on %s. Log in before then to keep your account.\n", deleteAfter.Format("January 2, 2006")),
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "sending account deletion email failed", "user_id", dbUser.ID, "error", err)
+	}
+	respondWithJSON(w, http.StatusAccepted, response{DeleteAfter: deleteAfter})
+}
+
+// purgeDeletedAccounts hard deletes accounts whose grace period is over.
+// Everything the user owns goes with them through ON DELETE CASCADE.
+func (cfg *apiConfig) purgeDeletedAccounts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cutoff := sql.NullTime{Time: time.Now().Add(-accountDeletionGracePeriod), Valid: true}
+		deleted, err := cfg.db.DeleteUsersPendingDeletion(ctx, cutoff)
+		if err != nil {
+			slog.ErrorContext(ctx, "purging deleted accounts failed", "error", err)
+		} else if deleted > 0 {
+			slog.InfoContext(ctx, "purged deleted accounts", "count", deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handlerUsersExport streams a ZIP archive with one JSON file per kind of
+// data chirpy keeps about the caller. Secrets such as password and token
+// hashes are left out.
+func (cfg *apiConfig) handlerUsersExport(w http.ResponseWriter, req *http.Request) {
+	type profile struct {
+		ID                  uuid.UUID  `json:"id"`
+		CreatedAt           time.Time  `json:"created_at"`
+		UpdatedAt           time.Time  `json:"updated_at"`
+		Email               string     `json:"email"`
+		EmailVerifiedAt     *time.Time `json:"email_verified_at"`
+		TwoFactorEnabledAt  *time.Time `json:"two_factor_enabled_at"`
+		DeletionRequestedAt *time.Time `json:"deletion_requested_at"`
+		Handle              string     `json:"handle"`
+		DisplayName         string     `json:"display_name"`
+		Bio                 string     `json:"bio"`
+	}
+	type chirp struct {
+		ID        uuid.UUID `json:"id"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+		Body      string    `json:"body"`
+	}
+	type session struct {
+		CreatedAt time.Time  `json:"created_at"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		RevokedAt *time.Time `json:"revoked_at"`
+		ClientID  string     `json:"client_id,omitempty"`
+		Scope     string     `json:"scope,omitempty"`
+	}
+	type membershipEvent struct {
+		CreatedAt        time.Time `json:"created_at"`
+		EventType        string    `json:"event_type"`
+		Plan             string    `json:"plan"`
+		Status           string    `json:"status"`
+		CurrentPeriodEnd time.Time `json:"current_period_end"`
+	}
+	type membership struct {
+		IsChirpyRed      bool              `json:"is_chirpy_red"`
+		Plan             string            `json:"plan,omitempty"`
+		Status           string            `json:"status,omitempty"`
+		CurrentPeriodEnd *time.Time        `json:"current_period_end,omitempty"`
+		History          []membershipEvent `json:"history"`
+	}
+	type identity struct {
+		Provider  string    `json:"provider"`
+		Subject   string    `json:"subject"`
+		Email     string    `json:"email"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	type passkey struct {
+		CreatedAt  time.Time  `json:"created_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		Transports string     `json:"transports"`
+	}
+
+	user, _ := authUserFromContext(req.Context())
+	dbUser, err := cfg.db.GetUserByID(req.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 404, "user cannot be found")
+		return
+	}
+	dbProfile, err := cfg.db.GetUserProfile(req.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithServerError(w, "fetching profile failed", err)
+		return
+	}
+	dbChirps, err := cfg.db.GetAllChirpsFromAuthorASC(req.Context(), user.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching chirps failed", err)
+		return
+	}
+	dbSessions, err := cfg.db.ListRefreshTokensForUser(req.Context(), user.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching sessions failed", err)
+		return
+	}
+	dbIdentities, err := cfg.db.ListIdentitiesForUser(req.Context(), user.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching identities failed", err)
+		return
+	}
+	dbPasskeys, err := cfg.db.ListWebauthnCredentials(req.Context(), user.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching passkeys failed", err)
+		return
+	}
+	dbSubscription, err := cfg.db.GetSubscription(req.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithServerError(w, "fetching subscription failed", err)
+		return
+	}
+	dbHistory, err := cfg.db.ListSubscriptionHistory(req.Context(), user.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching subscription history failed", err)
+		return
+	}
+
+	chirps := []chirp{}
+	for _, c := range dbChirps {
+		chirps = append(chirps, chirp{ID: c.ID, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt, Body: c.Body})
+	}
+	sessions := []session{}
+	for _, s := range dbSessions {
+		sessions = append(sessions, session{
+			CreatedAt: s.CreatedAt,
+			ExpiresAt: nullTimePtr(s.ExpiresAt),
+			RevokedAt: nullTimePtr(s.RevokedAt),
+			ClientID:  s.ClientID.String,
+			Scope:     s.Scope,
+		})
+	}
+	identities := []identity{}
+	for _, i := range dbIdentities {
+		identities = append(identities, identity{Provider: i.Provider, Subject: i.Subject, Email: i.Email, CreatedAt: i.CreatedAt})
+	}
+	passkeys := []passkey{}
+	for _, p := range dbPasskeys {
+		passkeys = append(passkeys, passkey{CreatedAt: p.CreatedAt, LastUsedAt: nullTimePtr(p.LastUsedAt), Transports: p.Transports})
+	}
+
+	member := membership{
+		IsChirpyRed: subscriptionFromDB(dbSubscription).Entitled(time.Now()),
+		Plan:        dbSubscription.Plan,
+		Status:      dbSubscription.Status,
+		History:     []membershipEvent{},
+	}
+	if dbSubscription.Status != "" {
+		member.CurrentPeriodEnd = &dbSubscription.CurrentPeriodEnd
+	}
+	for _, h := range dbHistory {
+		member.History = append(member.History, membershipEvent{
+			CreatedAt:        h.CreatedAt,
+			EventType:        h.EventType,
+			Plan:             h.Plan,
+			Status:           h.Status,
+			CurrentPeriodEnd: h.CurrentPeriodEnd,
+		})
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profile{
+			ID:                  dbUser.ID,
+			CreatedAt:           dbUser.CreatedAt,
+			UpdatedAt:           dbUser.UpdatedAt,
+			Email:               dbUser.Email,
+			EmailVerifiedAt:     nullTimePtr(dbUser.EmailVerifiedAt),
+			TwoFactorEnabledAt:  nullTimePtr(dbUser.TotpEnabledAt),
+			DeletionRequestedAt: nullTimePtr(dbUser.DeletionRequestedAt),
+			Handle:              dbProfile.Handle.String,
+			DisplayName:         dbProfile.DisplayName,
+			Bio:                 dbProfile.Bio,
+		}},
+		{"chirps.json", chirps},
+		{"sessions.json", sessions},
+		{"membership.json", member},
+		{"identities.json", identities},
+		{"passkeys.json", passkeys},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "writing account export failed", "user_id", user.ID, "error", err)
+			return
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			slog.ErrorContext(req.Context(), "writing account export failed", "user_id", user.ID, "error", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		slog.ErrorContext(req.Context(), "writing account export failed", "user_id", user.ID, "error", err)
+	}
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
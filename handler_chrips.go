@@ -1,14 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/chichigami/chirpy/internal/auth"
 	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/webhooks"
 	"github.com/google/uuid"
 )
 
@@ -19,31 +21,123 @@ func (cfg *apiConfig) handlerChirpsDeleteID(w http.ResponseWriter, req *http.Req
 		return
 	}
 
-	jwtToken, err := auth.GetBearerToken(req.Header)
+	user, _ := authUserFromContext(req.Context())
+
+	chirp, err := cfg.db.GetChirp(req.Context(), chirpID)
 	if err != nil {
-		respondWithError(w, 401, err.Error())
+		respondWithError(w, 404, "chirp is not found")
+		return
+	}
+	if chirp.UserID != user.ID {
+		respondWithError(w, 403, "authorization not valid")
 		return
 	}
+	if err := cfg.deleteChirp(req.Context(), chirp); err != nil {
+		respondWithServerError(w, "deleting chirp failed", err)
+		return
+	}
+	w.WriteHeader(204)
+}
 
-	userID, err := auth.ValidateJWT(jwtToken, cfg.jwtSecret)
+// handlerAdminChirpsDelete lets moderators remove any user's chirp.
+func (cfg *apiConfig) handlerAdminChirpsDelete(w http.ResponseWriter, req *http.Request) {
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, 401, err.Error())
+		respondWithError(w, 404, "invalid chirpID")
 		return
 	}
+	moderator, _ := authUserFromContext(req.Context())
 
 	chirp, err := cfg.db.GetChirp(req.Context(), chirpID)
 	if err != nil {
 		respondWithError(w, 404, "chirp is not found")
 		return
 	}
-	if chirp.UserID != userID {
-		respondWithError(w, 403, "authorization not valid")
+	if err := cfg.deleteChirp(req.Context(), chirp); err != nil {
+		respondWithServerError(w, "deleting chirp failed", err)
 		return
 	}
-	cfg.db.DeleteChirp(req.Context(), chirpID)
+	slog.InfoContext(req.Context(), "moderator deleted chirp", "moderator_id", moderator.ID, "chirp_id", chirp.ID, "author_id", chirp.UserID)
 	w.WriteHeader(204)
 }
 
+// deleteChirp removes chirp and queues chirp.deleted for its author's
+// webhooks in the same transaction.
+func (cfg *apiConfig) deleteChirp(ctx context.Context, chirp database.Chirp) error {
+	return cfg.inTx(ctx, func(q *database.Queries) error {
+		if err := q.DeleteChirp(ctx, chirp.ID); err != nil {
+			return err
+		}
+		return enqueueWebhookEvent(ctx, q, webhooks.EventChirpDeleted, chirp.UserID, newChirpResponse(chirp))
+	})
+}
+
+// handlerChirpsUpdate edits a chirp's body, which only plans with an edit
+// window allow and only until the window closes.
+func (cfg *apiConfig) handlerChirpsUpdate(w http.ResponseWriter, req *http.Request) {
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 404, "invalid chirpID")
+		return
+	}
+	user, _ := authUserFromContext(req.Context())
+
+	type parameter struct {
+		Body string `json:"body"`
+	}
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(req.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, 404, "chirp is not found")
+		return
+	}
+	if chirp.UserID != user.ID {
+		respondWithError(w, 403, "authorization not valid")
+		return
+	}
+
+	_, limits, err := cfg.userLimits(req.Context(), user.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching plan failed", err)
+		return
+	}
+	if limits.EditWindow == 0 {
+		respondWithError(w, 403, "your plan does not include editing chirps")
+		return
+	}
+	if time.Since(chirp.CreatedAt) > time.Duration(limits.EditWindow) {
+		respondWithError(w, 403, "the edit window for this chirp has passed")
+		return
+	}
+
+	validatedChirp, err := chirpsValidate(param.Body, limits.MaxChirpLength)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	dbChirp, err := cfg.db.UpdateChirpBody(req.Context(), database.UpdateChirpBodyParams{
+		ID:   chirpID,
+		Body: validatedChirp,
+	})
+	if err != nil {
+		respondWithServerError(w, "updating chirp failed", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ChirpResponse{
+		ID:        dbChirp.ID,
+		CreatedAt: dbChirp.CreatedAt,
+		UpdatedAt: dbChirp.UpdatedAt,
+		Body:      dbChirp.Body,
+		UserID:    dbChirp.UserID,
+	})
+}
+
 func (cfg *apiConfig) handlerChirpsGetID(w http.ResponseWriter, req *http.Request) {
 	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
 	if err != nil {
@@ -109,7 +203,7 @@ func (cfg *apiConfig) handlerChirpsGetAll(w http.ResponseWriter, req *http.Reque
 	}
 
 	if err != nil {
-		respondWithError(w, 500, err.Error())
+		respondWithServerError(w, err.Error(), err)
 		return
 	}
 
@@ -130,16 +224,7 @@ func (cfg *apiConfig) handlerChirpsGetAll(w http.ResponseWriter, req *http.Reque
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, req *http.Request) {
 	//POST /api/chirps
-	userToken, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		respondWithError(w, 401, err.Error())
-		return
-	}
-	userID, err := auth.ValidateJWT(userToken, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, 401, err.Error())
-		return
-	}
+	user, _ := authUserFromContext(req.Context())
 
 	type parameter struct {
 		Body string `json:"body"`
@@ -151,29 +236,51 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	validatedChirp, err := chirpsValidate(param.Body)
+	_, limits, err := cfg.userLimits(req.Context(), user.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching plan failed", err)
+		return
+	}
+
+	validatedChirp, err := chirpsValidate(param.Body, limits.MaxChirpLength)
 	if err != nil {
 		respondWithError(w, 400, err.Error())
+		return
 	}
-	dbchirp, err := cfg.db.CreateChirp(req.Context(), database.CreateChirpParams{
-		Body:   validatedChirp,
-		UserID: userID,
+	var response ChirpResponse
+	var wait time.Duration
+	err = cfg.inTx(req.Context(), func(q *database.Queries) error {
+		err := q.LockUser(req.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+		wait, err = chirpQuotaWait(req.Context(), q, user.ID, limits)
+		if err != nil || wait > 0 {
+			return err
+		}
+		dbchirp, err := q.CreateChirp(req.Context(), database.CreateChirpParams{
+			Body:   validatedChirp,
+			UserID: user.ID,
+		})
+		if err != nil {
+			return err
+		}
+		response = newChirpResponse(dbchirp)
+		return enqueueWebhookEvent(req.Context(), q, webhooks.EventChirpCreated, user.ID, response)
 	})
 	if err != nil {
-		respondWithError(w, 500, "chirp creation db error")
-	}
-	respondWithJSON(w, http.StatusCreated, ChirpResponse{
-		ID:        dbchirp.ID,
-		CreatedAt: dbchirp.CreatedAt,
-		UpdatedAt: dbchirp.UpdatedAt,
-		Body:      dbchirp.Body,
-		UserID:    dbchirp.UserID,
-	})
+		respondWithServerError(w, "chirp creation db error", err)
+		return
+	}
+	if wait > 0 {
+		respondChirpRateLimited(w, wait)
+		return
+	}
+	cfg.metrics.chirpsCreated.Inc()
+	respondWithJSON(w, http.StatusCreated, response)
 }
 
-func chirpsValidate(chirp string) (string, error) {
-	const chirpMaxLength = 140
-
+func chirpsValidate(chirp string, chirpMaxLength int) (string, error) {
 	if len(chirp) > chirpMaxLength {
 		return "", fmt.Errorf("chirp is too long")
 	}
@@ -208,3 +315,13 @@ type ChirpResponse struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	UserID    uuid.UUID `json:"user_id"`
 }
+
+func newChirpResponse(chirp database.Chirp) ChirpResponse {
+	return ChirpResponse{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body:      chirp.Body,
+		UserID:    chirp.UserID,
+	}
+}
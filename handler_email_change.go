@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/auth"
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/mailer"
+)
+
+const emailChangeDuration = 24 * time.Hour
+
+func (cfg *apiConfig) handlerUsersEmailConfirm(w http.ResponseWriter, req *http.Request) {
+	type parameter struct {
+		Token string `json:"token"`
+	}
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+
+	change, err := cfg.db.UseEmailChange(req.Context(), auth.HashToken(param.Token))
+	if err != nil {
+		respondWithError(w, 400, "email change token is invalid or expired")
+		return
+	}
+	dbUser, err := cfg.db.GetUserByID(req.Context(), change.UserID)
+	if err != nil {
+		respondWithError(w, 404, "user cannot be found")
+		return
+	}
+	// the address may have been taken since the change was requested
+	if _, err := cfg.db.GetUserByEmail(req.Context(), change.NewEmail); err == nil {
+		respondWithError(w, 409, "email is already in use")
+		return
+	}
+
+	err = cfg.db.ChangeEmail(req.Context(), database.ChangeEmailParams{
+		ID:    dbUser.ID,
+		Email: change.NewEmail,
+	})
+	if err != nil {
+		respondWithServerError(w, "changing email failed", err)
+		return
+	}
+
+	err = cfg.mailer.Send(req.Context(), mailer.Message{
+		To:      dbUser.Email,
+		Subject: "Your Chirpy email was changed",
+		Body:    fmt.Sprintf("The email on your Chirpy account was changed to %s. If you did not do this, reset your password and contact support.\n", change.NewEmail),
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "sending email change notice failed", "user_id", dbUser.ID, "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reauthenticate checks the current password before a sensitive account
+// change. Failures count towards the same lockout as logging in.
+func (cfg *apiConfig) reauthenticate(w http.ResponseWriter, req *http.Request, dbUser database.User, password string) bool {
+	attempt := &loginAttempt{key: accountThrottleKey(dbUser.Email), policy: accountThrottle}
+	wait, err := cfg.claimLoginAttempts(req.Context(), attempt)
+	if err != nil {
+		respondWithServerError(w, "checking login throttle failed", err)
+		return false
+	}
+	if wait > 0 {
+		respondTooManyLoginAttempts(w, wait)
+		return false
+	}
+	if err := auth.CheckPasswordHash(dbUser.HashedPassword, password); err != nil {
+		cfg.loginFailed(req.Context(), attempt)
+		respondWithError(w, http.StatusUnauthorized, "current password is incorrect")
+		return false
+	}
+	cfg.loginSucceeded(req.Context(), attempt)
+	return true
+}
+
+// changePassword stores the new password, revokes every refresh token,
+// the caller's included, and tells the account owner about it. The caller's
+// access token keeps working until it expires; after that they log in with
+// the new password like every other session.
+func (cfg *apiConfig) changePassword(w http.ResponseWriter, req *http.Request, dbUser database.User, password string) bool {
+	hashedPass, err := auth.HashPassword(password)
+	if err != nil {
+		respondWithServerError(w, "failed to hash password", err)
+		return false
+	}
+	err = cfg.db.UpdatePassword(req.Context(), database.UpdatePasswordParams{
+		ID:             dbUser.ID,
+		HashedPassword: hashedPass,
+	})
+	if err != nil {
+		respondWithServerError(w, "updating password failed", err)
+		return false
+	}
+	if err := cfg.db.RevokeAllRefreshTokensForUser(req.Context(), dbUser.ID); err != nil {
+		respondWithServerError(w, "revoking refresh tokens failed", err)
+		return false
+	}
+
+	err = cfg.mailer.Send(req.Context(), mailer.Message{
+		To:      dbUser.Email,
+		Subject: "Your Chirpy password was changed",
+		Body:    "The password on your Chirpy account was just changed and all sessions were signed out. If you did not do this, reset your password right away.\n",
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "sending password change notice failed", "user_id", dbUser.ID, "error", err)
+	}
+	return true
+}
+
+// sendEmailChange mails a confirmation token to the new address and a
+// heads-up to the current one. Only the latest pending change stays valid.
+func (cfg *apiConfig) sendEmailChange(ctx context.Context, dbUser database.User, newEmail string) error {
+	if err := cfg.db.InvalidateEmailChanges(ctx, dbUser.ID); err != nil {
+		return err
+	}
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	_, err = cfg.db.CreateEmailChange(ctx, database.CreateEmailChangeParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    dbUser.ID,
+		NewEmail:  newEmail,
+		ExpiresAt: time.Now().Add(emailChangeDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new Chirpy email",
+		Body:    fmt.Sprintf("Use this token with POST /api/users/email/confirm to make this your Chirpy email:\n\n%s\n\nIt expires in 24 hours.\n", token),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      dbUser.Email,
+		Subject: "Chirpy email change requested",
+		Body:    fmt.Sprintf("Someone asked to change the email on your Chirpy account to %s. Nothing changes until the new address is confirmed. If this was not you, reset your password.\n", newEmail),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "sending email change request notice failed", "user_id", dbUser.ID, "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/auth"
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/mailer"
+)
+
+const (
+	emailVerificationDuration = 24 * time.Hour
+	emailVerificationCooldown = time.Minute
+)
+
+func (cfg *apiConfig) handlerUsersVerify(w http.ResponseWriter, req *http.Request) {
+	type parameter struct {
+		Token string `json:"token"`
+	}
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+
+	userID, err := cfg.db.UseEmailVerification(req.Context(), auth.HashToken(param.Token))
+	if err != nil {
+		respondWithError(w, 400, "verification token is invalid or expired")
+		return
+	}
+	if err := cfg.db.MarkEmailVerified(req.Context(), userID); err != nil {
+		respondWithServerError(w, "marking email verified failed", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerUsersVerifyResend(w http.ResponseWriter, req *http.Request) {
+	user, _ := authUserFromContext(req.Context())
+
+	dbUser, err := cfg.db.GetUserByID(req.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 404, "user cannot be found")
+		return
+	}
+	if dbUser.EmailVerifiedAt.Valid {
+		respondWithError(w, 409, "email is already verified")
+		return
+	}
+
+	latest, err := cfg.db.GetLatestEmailVerification(req.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithServerError(w, "fetching verification failed", err)
+		return
+	}
+	if err == nil {
+		if wait := emailVerificationCooldown - time.Since(latest.CreatedAt); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondWithError(w, http.StatusTooManyRequests, "verification email was sent recently")
+			return
+		}
+	}
+
+	if err := cfg.sendEmailVerification(req.Context(), dbUser); err != nil {
+		respondWithServerError(w, "sending verification email failed", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) sendEmailVerification(ctx context.Context, dbUser database.User) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	_, err = cfg.db.CreateEmailVerification(ctx, database.CreateEmailVerificationParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    dbUser.ID,
+		ExpiresAt: time.Now().Add(emailVerificationDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      dbUser.Email,
+		Subject: "Verify your Chirpy email",
+		Body:    fmt.Sprintf("Use this token with POST /api/users/verify to verify your email:\n\n%s\n\nIt expires in 24 hours.\n", token),
+	})
+}
@@ -1,9 +1,121 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"embed"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
 
-func handlerHealthz(w http.ResponseWriter, req *http.Request) {
+//go:embed sql/schema/*.sql
+var migrations embed.FS
+
+// readinessCheckTimeout bounds each dependency check so a hung database
+// makes the probe fail rather than hang.
+const readinessCheckTimeout = 2 * time.Second
+
+type componentStatus struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+	Version   int64  `json:"version,omitempty"`
+	Expected  int64  `json:"expected,omitempty"`
+}
+
+// handlerLivez only says the process is up and serving; it never checks
+// dependencies, so a database outage doesn't get every instance restarted.
+func handlerLivez(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(200)
 	w.Write([]byte("OK"))
 }
+
+// handlerReadyz reports whether this instance should get traffic: the
+// database answers, its schema is at least as new as the migrations built
+// into the binary, and the server isn't draining for shutdown.
+func (cfg *apiConfig) handlerReadyz(w http.ResponseWriter, req *http.Request) {
+	type response struct {
+		Status     string                     `json:"status"`
+		Components map[string]componentStatus `json:"components"`
+	}
+
+	components := map[string]componentStatus{
+		"database":   cfg.checkDatabase(req.Context()),
+		"migrations": cfg.checkMigrations(req.Context()),
+		"server":     {Status: "ok"},
+	}
+	if cfg.draining.Load() {
+		components["server"] = componentStatus{Status: "unavailable", Error: "draining for shutdown"}
+	}
+
+	resp := response{Status: "ok", Components: components}
+	code := http.StatusOK
+	for _, component := range components {
+		if component.Status != "ok" {
+			resp.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, resp)
+}
+
+func (cfg *apiConfig) checkDatabase(ctx context.Context) componentStatus {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := cfg.sqlDB.PingContext(ctx); err != nil {
+		slog.ErrorContext(ctx, "readiness check failed", "component", "database", "error", err)
+		return componentStatus{Status: "unavailable", Error: "database unreachable"}
+	}
+	return componentStatus{Status: "ok", LatencyMs: time.Since(start).Milliseconds()}
+}
+
+// checkMigrations compares goose's version table with the newest migration
+// shipped in sql/schema. A newer database is fine, which lets migrations
+// run ahead of a rollout.
+func (cfg *apiConfig) checkMigrations(ctx context.Context) componentStatus {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	expected, err := latestMigration()
+	if err != nil {
+		slog.ErrorContext(ctx, "readiness check failed", "component", "migrations", "error", err)
+		return componentStatus{Status: "unavailable", Error: "reading built-in migrations failed"}
+	}
+	var version int64
+	err = cfg.sqlDB.QueryRowContext(ctx, "SELECT version_id FROM goose_db_version WHERE is_applied ORDER BY id DESC LIMIT 1").Scan(&version)
+	if err != nil {
+		slog.ErrorContext(ctx, "readiness check failed", "component", "migrations", "error", err)
+		return componentStatus{Status: "unavailable", Error: "reading schema version failed", Expected: expected}
+	}
+	status := componentStatus{Status: "ok", Version: version, Expected: expected}
+	if version < expected {
+		status.Status = "unavailable"
+		status.Error = "database schema is behind, run the migrations"
+	}
+	return status
+}
+
+// latestMigration is the version of the newest file in sql/schema, taken
+// from goose's NNN_name.sql naming.
+func latestMigration() (int64, error) {
+	files, err := migrations.ReadDir("sql/schema")
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	for _, file := range files {
+		prefix, _, _ := strings.Cut(path.Base(file.Name()), "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		latest = max(latest, version)
+	}
+	return latest, nil
+}
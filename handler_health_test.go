@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLatestMigration(t *testing.T) {
+	latest, err := latestMigration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(fmt.Sprintf("sql/schema/%03d_*.sql", latest)); len(files) != 1 {
+		t.Errorf("latestMigration() = %d, but sql/schema has no such migration", latest)
+	}
+	if files, _ := filepath.Glob(fmt.Sprintf("sql/schema/%03d_*.sql", latest+1)); len(files) != 0 {
+		t.Errorf("latestMigration() = %d, but sql/schema has %s", latest, files[0])
+	}
+}
+
+func TestHandlerReadyz(t *testing.T) {
+	latest, err := latestMigration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		pingErr       error
+		schemaVersion int64
+		draining      bool
+		wantStatus    int
+		// wantErrors maps each unavailable component to its error
+		wantErrors map[string]string
+	}{
+		{"ready", nil, latest, false, 200, nil},
+		{"schema ahead of the binary", nil, latest + 1, false, 200, nil},
+		{"draining", nil, latest, true, 503, map[string]string{"server": "draining for shutdown"}},
+		{"schema behind", nil, latest - 1, false, 503, map[string]string{"migrations": "database schema is behind, run the migrations"}},
+		{"database down", errors.New("dial tcp 10.0.0.5:5432: connection refused"), latest, false, 503, map[string]string{"database": "database unreachable"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			db.pingErr = tt.pingErr
+			// the goose version query has no sqlc name
+			db.returns("query", []any{tt.schemaVersion})
+			cfg := db.config()
+			cfg.draining.Store(tt.draining)
+
+			resp := httptest.NewRecorder()
+			cfg.handlerReadyz(resp, httptest.NewRequest(http.MethodGet, "/api/readyz", nil))
+
+			if resp.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", resp.Code, tt.wantStatus, resp.Body)
+			}
+			// unauthenticated callers must not see driver errors
+			if tt.pingErr != nil && strings.Contains(resp.Body.String(), "10.0.0.5") {
+				t.Errorf("body leaks the database error: %s", resp.Body)
+			}
+			var body struct {
+				Components map[string]componentStatus `json:"components"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			for name, component := range body.Components {
+				want, unavailable := tt.wantErrors[name]
+				if unavailable != (component.Status != "ok") || component.Error != want {
+					t.Errorf("%s = %+v, want error %q", name, component, want)
+				}
+			}
+		})
+	}
+}
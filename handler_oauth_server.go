@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/auth"
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const authorizationCodeDuration = 5 * time.Minute
+
+// oauthScopes are the scopes third-party apps can ask for, with the text
+// shown to the user on the consent screen.
+var oauthScopes = map[string]string{
+	"chirps:write": "Post and delete chirps as you",
+}
+
+var errInvalidClient = errors.New("client authentication failed")
+
+func (cfg *apiConfig) handlerOAuthClientsCreate(w http.ResponseWriter, req *http.Request) {
+	type parameter struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+	type response struct {
+		ClientID     string    `json:"client_id"`
+		ClientSecret string    `json:"client_secret,omitempty"`
+		CreatedAt    time.Time `json:"created_at"`
+		Name         string    `json:"name"`
+		RedirectURIs []string  `json:"redirect_uris"`
+	}
+
+	user, _ := authUserFromContext(req.Context())
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+	if strings.TrimSpace(param.Name) == "" {
+		respondWithError(w, 400, "client name is required")
+		return
+	}
+	if len(param.RedirectURIs) == 0 {
+		respondWithError(w, 400, "at least one redirect uri is required")
+		return
+	}
+	for _, redirectURI := range param.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			respondWithError(w, 400, "invalid redirect uri: "+redirectURI)
+			return
+		}
+	}
+
+	secret := ""
+	secretHash := sql.NullString{}
+	if param.Confidential {
+		var err error
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithServerError(w, "client secret generation failed", err)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.db.CreateOAuthClient(req.Context(), database.CreateOAuthClientParams{
+		ID:           uuid.New().String(),
+		UserID:       user.ID,
+		Name:         param.Name,
+		SecretHash:   secretHash,
+		RedirectUris: strings.Join(param.RedirectURIs, " "),
+	})
+	if err != nil {
+		respondWithServerError(w, "client creation db error", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		ClientID:     client.ID,
+		ClientSecret: secret,
+		CreatedAt:    client.CreatedAt,
+		Name:         client.Name,
+		RedirectURIs: strings.Fields(client.RedirectUris),
+	})
+}
+
+// authorizeRequest holds the parameters of an authorization request. GET
+// reads them from the query string to show the consent screen, POST reads
+// them from the JSON body together with the user's decision.
+type authorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+func (cfg *apiConfig) handlerOAuthAuthorizeInfo(w http.ResponseWriter, req *http.Request) {
+	type scope struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	type response struct {
+		ClientID    string  `json:"client_id"`
+		ClientName  string  `json:"client_name"`
+		RedirectURI string  `json:"redirect_uri"`
+		Scopes      []scope `json:"scopes"`
+	}
+
+	query := req.URL.Query()
+	authReq := authorizeRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+	client, scopes, ok := cfg.validateAuthorizeRequest(w, req.Context(), authReq)
+	if !ok {
+		return
+	}
+
+	consent := response{
+		ClientID:    client.ID,
+		ClientName:  client.Name,
+		RedirectURI: authReq.RedirectURI,
+	}
+	for _, name := range scopes {
+		consent.Scopes = append(consent.Scopes, scope{Name: name, Description: oauthScopes[name]})
+	}
+	respondWithJSON(w, http.StatusOK, consent)
+}
+
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, req *http.Request) {
+	type response struct {
+		RedirectURI string `json:"redirect_uri"`
+	}
+
+	user, _ := authUserFromContext(req.Context())
+	authReq := authorizeRequest{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&authReq); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+	client, scopes, ok := cfg.validateAuthorizeRequest(w, req.Context(), authReq)
+	if !ok {
+		return
+	}
+
+	redirect, _ := url.Parse(authReq.RedirectURI)
+	query := redirect.Query()
+	if authReq.State != "" {
+		query.Set("state", authReq.State)
+	}
+	if !authReq.Approve {
+		query.Set("error", "access_denied")
+		redirect.RawQuery = query.Encode()
+		respondWithJSON(w, http.StatusOK, response{RedirectURI: redirect.String()})
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithServerError(w, "authorization code generation failed", err)
+		return
+	}
+	err = cfg.db.CreateAuthorizationCode(req.Context(), database.CreateAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      client.ID,
+		UserID:        user.ID,
+		RedirectUri:   authReq.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		CodeChallenge: authReq.CodeChallenge,
+		ExpiresAt:     time.Now().Add(authorizationCodeDuration),
+	})
+	if err != nil {
+		respondWithServerError(w, "authorization code creation db error", err)
+		return
+	}
+
+	query.Set("code", code)
+	redirect.RawQuery = query.Encode()
+	respondWithJSON(w, http.StatusOK, response{RedirectURI: redirect.String()})
+}
+
+// validateAuthorizeRequest checks the client, its registered redirect uri
+// and the PKCE challenge, and returns the normalized list of scopes.
+func (cfg *apiConfig) validateAuthorizeRequest(w http.ResponseWriter, ctx context.Context, authReq authorizeRequest) (database.OauthClient, []string, bool) {
+	client, err := cfg.db.GetOAuthClient(ctx, authReq.ClientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, "unknown client")
+		return database.OauthClient{}, nil, false
+	}
+	if err != nil {
+		respondWithServerError(w, "fetching client failed", err)
+		return database.OauthClient{}, nil, false
+	}
+	if !slices.Contains(strings.Fields(client.RedirectUris), authReq.RedirectURI) {
+		respondWithError(w, 400, "redirect uri is not registered for this client")
+		return database.OauthClient{}, nil, false
+	}
+	if authReq.ResponseType != "code" {
+		respondWithError(w, 400, "response_type must be code")
+		return database.OauthClient{}, nil, false
+	}
+	if authReq.CodeChallengeMethod != "S256" || authReq.CodeChallenge == "" {
+		respondWithError(w, 400, "a S256 PKCE code challenge is required")
+		return database.OauthClient{}, nil, false
+	}
+	scopes, err := parseOAuthScope(authReq.Scope)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return database.OauthClient{}, nil, false
+	}
+	return client, scopes, true
+}
+
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, req *http.Request) {
+	client, err := cfg.authenticateOAuthClient(req)
+	if err != nil {
+		respondOAuthInvalidClient(w, req)
+		return
+	}
+
+	switch req.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := cfg.db.ConsumeAuthorizationCode(req.Context(), auth.HashToken(req.PostForm.Get("code")))
+		if err != nil {
+			respondWithOAuthError(w, 400, "invalid_grant", "authorization code is invalid or expired")
+			return
+		}
+		if code.ClientID != client.ID || code.RedirectUri != req.PostForm.Get("redirect_uri") {
+			respondWithOAuthError(w, 400, "invalid_grant", "authorization code was issued to another client or redirect uri")
+			return
+		}
+		if !auth.VerifyPKCE(req.PostForm.Get("code_verifier"), code.CodeChallenge) {
+			respondWithOAuthError(w, 400, "invalid_grant", "code verifier does not match the code challenge")
+			return
+		}
+		cfg.respondWithOAuthTokens(w, req, client.ID, code.UserID, code.Scope)
+
+	case "refresh_token":
+		tokenHash := auth.HashToken(req.PostForm.Get("refresh_token"))
+		refreshToken, err := cfg.db.GetRefreshToken(req.Context(), tokenHash)
+		if err != nil || refreshToken.ClientID.String != client.ID || refreshTokenExpired(refreshToken) {
+			respondWithOAuthError(w, 400, "invalid_grant", "refresh token is invalid or expired")
+			return
+		}
+		scope := refreshToken.Scope
+		if requested := req.PostForm.Get("scope"); requested != "" {
+			scopes, err := parseOAuthScope(requested)
+			if err != nil {
+				respondWithOAuthError(w, 400, "invalid_scope", err.Error())
+				return
+			}
+			granted := strings.Fields(refreshToken.Scope)
+			for _, s := range scopes {
+				if !slices.Contains(granted, s) {
+					respondWithOAuthError(w, 400, "invalid_scope", "scope exceeds what the user granted")
+					return
+				}
+			}
+			scope = strings.Join(scopes, " ")
+		}
+
+		// refresh tokens are single use, rotate it before issuing a new one
+		revoked, err := cfg.db.RevokeActiveRefreshToken(req.Context(), tokenHash)
+		if err != nil {
+			respondWithOAuthError(w, 500, "server_error", "revoking refresh token failed")
+			return
+		}
+		if revoked == 0 {
+			respondWithOAuthError(w, 400, "invalid_grant", "refresh token is invalid or expired")
+			return
+		}
+		cfg.respondWithOAuthTokens(w, req, client.ID, refreshToken.UserID, scope)
+
+	default:
+		respondWithOAuthError(w, 400, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+	}
+}
+
+func (cfg *apiConfig) respondWithOAuthTokens(w http.ResponseWriter, req *http.Request, clientID string, userID uuid.UUID, scope string) {
+	type response struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	accessToken, err := auth.MakeClientJWT(userID, cfg.jwtSecret, cfg.oauthAccessTokenTTL, clientID, scope)
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error", "access token generation failed")
+		return
+	}
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error", "refresh token generation failed")
+		return
+	}
+	_, err = cfg.db.CreateClientRefreshToken(req.Context(), database.CreateClientRefreshTokenParams{
+		Token:     auth.HashToken(refreshToken),
+		UserID:    userID,
+		ExpiresAt: sql.NullTime{Time: time.Now().Add(cfg.oauthRefreshTokenTTL), Valid: true},
+		ClientID:  sql.NullString{String: clientID, Valid: true},
+		Scope:     scope,
+	})
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error", "refresh token creation db error")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	respondWithJSON(w, http.StatusOK, response{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(cfg.oauthAccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	})
+}
+
+// handlerOAuthRevoke implements RFC 7009. Only refresh tokens can be revoked;
+// access tokens are short-lived JWTs and simply expire.
+func (cfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, req *http.Request) {
+	client, err := cfg.authenticateOAuthClient(req)
+	if err != nil {
+		respondOAuthInvalidClient(w, req)
+		return
+	}
+
+	// unknown tokens are not an error, the client just has nothing to revoke
+	tokenHash := auth.HashToken(req.PostForm.Get("token"))
+	refreshToken, err := cfg.db.GetRefreshToken(req.Context(), tokenHash)
+	if err == nil && refreshToken.ClientID.String == client.ID {
+		if err := cfg.db.RevokeRefreshToken(req.Context(), tokenHash); err != nil {
+			respondWithOAuthError(w, 500, "server_error", "revoking refresh token failed")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlerOAuthIntrospect implements RFC 7662. A client may only introspect
+// tokens that were issued to it.
+func (cfg *apiConfig) handlerOAuthIntrospect(w http.ResponseWriter, req *http.Request) {
+	type response struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		IssuedAt  int64  `json:"iat,omitempty"`
+	}
+
+	client, err := cfg.authenticateOAuthClient(req)
+	if err != nil {
+		respondOAuthInvalidClient(w, req)
+		return
+	}
+	token := req.PostForm.Get("token")
+
+	if claims, err := auth.ParseJWT(token, cfg.jwtSecret); err == nil {
+		if claims.ClientID != client.ID {
+			respondWithJSON(w, http.StatusOK, response{Active: false})
+			return
+		}
+		respondWithJSON(w, http.StatusOK, response{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "access_token",
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+		})
+		return
+	}
+
+	refreshToken, err := cfg.db.GetRefreshToken(req.Context(), auth.HashToken(token))
+	if err != nil || refreshToken.ClientID.String != client.ID || refreshTokenExpired(refreshToken) {
+		respondWithJSON(w, http.StatusOK, response{Active: false})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Active:    true,
+		Scope:     refreshToken.Scope,
+		ClientID:  client.ID,
+		Subject:   refreshToken.UserID.String(),
+		TokenType: "refresh_token",
+		ExpiresAt: refreshToken.ExpiresAt.Time.Unix(),
+		IssuedAt:  refreshToken.CreatedAt.Unix(),
+	})
+}
+
+// authenticateOAuthClient parses the form body and identifies the client by
+// HTTP Basic or client_secret_post credentials. Public clients send only
+// their client_id.
+func (cfg *apiConfig) authenticateOAuthClient(req *http.Request) (database.OauthClient, error) {
+	if err := req.ParseForm(); err != nil {
+		return database.OauthClient{}, err
+	}
+	clientID, secret, ok := req.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = req.PostForm.Get("client_id")
+		secret = req.PostForm.Get("client_secret")
+	}
+	if clientID == "" {
+		return database.OauthClient{}, errInvalidClient
+	}
+
+	client, err := cfg.db.GetOAuthClient(req.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, errInvalidClient
+	}
+	if !client.SecretHash.Valid {
+		if secret != "" {
+			return database.OauthClient{}, errInvalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		return database.OauthClient{}, errInvalidClient
+	}
+	return client, nil
+}
+
+func refreshTokenExpired(refreshToken database.RefreshToken) bool {
+	if refreshToken.RevokedAt.Valid {
+		return true
+	}
+	return refreshToken.ExpiresAt.Valid && time.Now().After(refreshToken.ExpiresAt.Time)
+}
+
+// parseOAuthScope splits a space-separated scope, rejects unknown scopes and
+// returns the rest sorted and without duplicates.
+func parseOAuthScope(scope string) ([]string, error) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return nil, errors.New("scope is required")
+	}
+	for _, s := range scopes {
+		if _, ok := oauthScopes[s]; !ok {
+			return nil, errors.New("unknown scope: " + s)
+		}
+	}
+	slices.Sort(scopes)
+	return slices.Compact(scopes), nil
+}
+
+// validRedirectURI only allows absolute https uris, plus plain http for
+// apps running on the user's own machine.
+func validRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Host == "" || u.Fragment != "" || strings.ContainsAny(redirectURI, " \t\n") {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, errCode, description string) {
+	type OAuthErrorResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, OAuthErrorResponse{Error: errCode, ErrorDescription: description})
+}
+
+func respondOAuthInvalidClient(w http.ResponseWriter, req *http.Request) {
+	if _, _, ok := req.BasicAuth(); ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", errInvalidClient.Error())
+}
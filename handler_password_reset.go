@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/auth"
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/mailer"
+)
+
+const passwordResetDuration = 30 * time.Minute
+
+func (cfg *apiConfig) handlerPasswordForgot(w http.ResponseWriter, req *http.Request) {
+	type parameter struct {
+		Email string `json:"email"`
+	}
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+
+	// always answer the same way so the endpoint can't be used to probe for accounts
+	dbUser, err := cfg.db.GetUserByEmail(req.Context(), param.Email)
+	if err != nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithServerError(w, "reset token generation failed", err)
+		return
+	}
+	_, err = cfg.db.CreatePasswordReset(req.Context(), database.CreatePasswordResetParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    dbUser.ID,
+		ExpiresAt: time.Now().Add(passwordResetDuration),
+	})
+	if err != nil {
+		respondWithServerError(w, "reset token creation db error", err)
+		return
+	}
+
+	err = cfg.mailer.Send(req.Context(), mailer.Message{
+		To:      dbUser.Email,
+		Subject: "Reset your Chirpy password",
+		Body:    fmt.Sprintf("Use this token with POST /api/password/reset to choose a new password:\n\n%s\n\nIt expires in 30 minutes. If you did not ask for this, you can ignore this email.\n", token),
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "sending password reset email failed", "user_id", dbUser.ID, "error", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, req *http.Request) {
+	type parameter struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+
+	if !cfg.validatePassword(w, param.Password) {
+		return
+	}
+
+	userID, err := cfg.db.UsePasswordReset(req.Context(), auth.HashToken(param.Token))
+	if err != nil {
+		respondWithError(w, 400, "reset token is invalid or expired")
+		return
+	}
+
+	hashedPass, err := auth.HashPassword(param.Password)
+	if err != nil {
+		respondWithServerError(w, "failed to hash password", err)
+		return
+	}
+	err = cfg.db.UpdatePassword(req.Context(), database.UpdatePasswordParams{
+		ID:             userID,
+		HashedPassword: hashedPass,
+	})
+	if err != nil {
+		respondWithServerError(w, "updating password failed", err)
+		return
+	}
+
+	if err := cfg.db.InvalidatePasswordResets(req.Context(), userID); err != nil {
+		slog.ErrorContext(req.Context(), "invalidating password resets failed", "user_id", userID, "error", err)
+	}
+	if err := cfg.db.RevokeAllRefreshTokensForUser(req.Context(), userID); err != nil {
+		respondWithServerError(w, "revoking refresh tokens failed", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,59 +1,258 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/chichigami/chirpy/internal/auth"
 	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/subscription"
 	"github.com/google/uuid"
 )
 
+const (
+	polkaWebhookTolerance = 5 * time.Minute
+	maxWebhookBodyBytes   = 1 << 20
+)
+
+const (
+	webhookOutcomeProcessed = "processed"
+	webhookOutcomeIgnored   = "ignored"
+	webhookOutcomeFailed    = "failed"
+)
+
+type polkaEvent struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	Data  struct {
+		UserID           string     `json:"user_id"`
+		Plan             string     `json:"plan"`
+		CurrentPeriodEnd *time.Time `json:"current_period_end"`
+	} `json:"data"`
+}
+
 func (cfg *apiConfig) handlerPolkaWebhook(w http.ResponseWriter, req *http.Request) {
-	type parameter struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID string `json:"user_id"`
-		} `json:"data"`
+	// the signature covers the raw bytes, so read them before parsing anything
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxWebhookBodyBytes))
+	if err != nil {
+		respondWithError(w, 400, "reading webhook body failed")
+		return
 	}
-
-	param := parameter{}
-	decoder := json.NewDecoder(req.Body)
-	if decodeErr := decoder.Decode(&param); decodeErr != nil {
-		respondWithError(w, 400, decodeErr.Error())
+	if err := cfg.authenticatePolka(req, body); err != nil {
+		respondWithError(w, 401, err.Error())
 		return
 	}
 
-	clientAPIKey, err := auth.GetAPIKey(req.Header)
+	// Polka retries until it gets a 2xx, so every delivery is recorded and
+	// deduplicated on its event id. The body alone can't stand in for a
+	// missing id: upgrading, downgrading and upgrading again sends the same
+	// bytes twice. A signed delivery is keyed on its signed timestamp as
+	// well; anything else is recorded without deduplication.
+	event := polkaEvent{}
+	json.Unmarshal(body, &event)
+	eventID := event.ID
+	if eventID == "" {
+		eventID = req.Header.Get("X-Polka-Event-Id")
+	}
+	if eventID == "" && len(cfg.polkaSecrets) > 0 {
+		eventID = "sha256:" + auth.HashToken(req.Header.Get("X-Polka-Timestamp")+"."+string(body))
+	}
+	if eventID == "" {
+		eventID = "unkeyed:" + uuid.NewString()
+	}
+
+	ledger, err := cfg.db.InsertWebhookEvent(req.Context(), database.InsertWebhookEventParams{
+		Source:    "polka",
+		EventID:   eventID,
+		EventType: event.Event,
+		Payload:   string(body),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		ledger, err = cfg.db.GetWebhookEventBySourceID(req.Context(), database.GetWebhookEventBySourceIDParams{
+			Source:  "polka",
+			EventID: eventID,
+		})
+		if err == nil && ledger.Outcome != webhookOutcomeFailed && ledger.ProcessedAt.Valid {
+			slog.InfoContext(req.Context(), "skipping duplicate polka event", "event_id", eventID)
+			w.WriteHeader(204)
+			return
+		}
+	}
 	if err != nil {
-		respondWithError(w, 401, err.Error())
+		respondWithServerError(w, "recording webhook failed", err)
 		return
 	}
 
-	if clientAPIKey != cfg.polka {
-		respondWithError(w, 401, "key does not match")
+	_, status, err := cfg.runPolkaEvent(req.Context(), ledger)
+	if err != nil && status >= 300 {
+		respondWithError(w, status, err.Error())
 		return
 	}
+	w.WriteHeader(status)
+}
+
+// runPolkaEvent applies a recorded event and stores the outcome in the
+// ledger. It backs both live deliveries and admin replays.
+func (cfg *apiConfig) runPolkaEvent(ctx context.Context, ledger database.WebhookEvent) (database.WebhookEvent, int, error) {
+	outcome, status, processErr := cfg.processPolkaEvent(ctx, ledger)
+	errMsg := ""
+	if processErr != nil {
+		errMsg = processErr.Error()
+	}
+	finished, err := cfg.db.FinishWebhookEvent(ctx, database.FinishWebhookEventParams{
+		ID:      ledger.ID,
+		Outcome: outcome,
+		Error:   errMsg,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "recording webhook event outcome failed", "webhook_event_id", ledger.ID, "error", err)
+		finished = ledger
+	}
+	cfg.metrics.webhookEvents.WithLabelValues(ledger.Source, outcome).Inc()
+	return finished, status, processErr
+}
+
+func (cfg *apiConfig) processPolkaEvent(ctx context.Context, ledger database.WebhookEvent) (string, int, error) {
+	event := polkaEvent{}
+	if err := json.Unmarshal([]byte(ledger.Payload), &event); err != nil {
+		return webhookOutcomeFailed, 400, err
+	}
+
+	if !subscription.Known(event.Event) {
+		return webhookOutcomeIgnored, 204, nil
+	}
 
-	if param.Event != "user.upgraded" {
-		w.WriteHeader(204)
+	userID, err := uuid.Parse(event.Data.UserID)
+	if err != nil {
+		return webhookOutcomeFailed, 500, errors.New("problem with parsing user id")
+	}
+	subEvent := subscription.Event{Type: event.Event, Plan: event.Data.Plan}
+	if event.Data.CurrentPeriodEnd != nil {
+		subEvent.PeriodEnd = *event.Data.CurrentPeriodEnd
+	}
+
+	err = cfg.applySubscriptionEvent(ctx, userID, subEvent, uuid.NullUUID{UUID: ledger.ID, Valid: true})
+	if errors.Is(err, sql.ErrNoRows) {
+		return webhookOutcomeFailed, 404, errors.New("user cannot be found")
+	}
+	// an event that doesn't fit the subscription's state won't fit on a retry
+	// either, so acknowledge it and keep the reason in the ledger
+	if errors.Is(err, errInvalidSubscriptionEvent) {
+		return webhookOutcomeIgnored, 204, err
+	}
+	if err != nil {
+		return webhookOutcomeFailed, 500, errors.New("updating subscription failed")
+	}
+	return webhookOutcomeProcessed, 204, nil
+}
+
+type webhookEventResponse struct {
+	ID          uuid.UUID  `json:"id"`
+	Source      string     `json:"source"`
+	EventID     string     `json:"event_id"`
+	EventType   string     `json:"event_type"`
+	Payload     string     `json:"payload"`
+	ReceivedAt  time.Time  `json:"received_at"`
+	ProcessedAt *time.Time `json:"processed_at"`
+	Outcome     string     `json:"outcome"`
+	Error       string     `json:"error,omitempty"`
+	Attempts    int32      `json:"attempts"`
+}
+
+func newWebhookEventResponse(event database.WebhookEvent) webhookEventResponse {
+	return webhookEventResponse{
+		ID:          event.ID,
+		Source:      event.Source,
+		EventID:     event.EventID,
+		EventType:   event.EventType,
+		Payload:     event.Payload,
+		ReceivedAt:  event.ReceivedAt,
+		ProcessedAt: nullTimePtr(event.ProcessedAt),
+		Outcome:     event.Outcome,
+		Error:       event.Error,
+		Attempts:    event.Attempts,
+	}
+}
+
+func (cfg *apiConfig) handlerAdminWebhooksList(w http.ResponseWriter, req *http.Request) {
+	limit := 50
+	if limitParam := req.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 1 || parsed > 500 {
+			respondWithError(w, 400, "limit must be between 1 and 500")
+			return
+		}
+		limit = parsed
+	}
+	outcome := req.URL.Query().Get("outcome")
+
+	events, err := cfg.db.ListWebhookEvents(req.Context(), database.ListWebhookEventsParams{
+		Limit:   int32(limit),
+		Outcome: sql.NullString{String: outcome, Valid: outcome != ""},
+	})
+	if err != nil {
+		respondWithServerError(w, "fetching webhook events failed", err)
 		return
 	}
+	response := []webhookEventResponse{}
+	for _, event := range events {
+		response = append(response, newWebhookEventResponse(event))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
 
-	userID, err := uuid.Parse(param.Data.UserID)
+func (cfg *apiConfig) handlerAdminWebhookReplay(w http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
 	if err != nil {
-		respondWithError(w, 500, "problem with parsing user id")
+		respondWithError(w, 404, "invalid webhook event id")
+		return
+	}
+	admin, _ := authUserFromContext(req.Context())
+
+	ledger, err := cfg.db.GetWebhookEvent(req.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "webhook event cannot be found")
 		return
 	}
-	err = cfg.db.UpdateMembership(req.Context(), database.UpdateMembershipParams{
-		ID:          userID,
-		IsChirpyRed: sql.NullBool{Bool: true, Valid: true},
-	})
 	if err != nil {
-		respondWithError(w, 404, "user cannot be found")
+		respondWithServerError(w, "fetching webhook event failed", err)
 		return
 	}
-	w.WriteHeader(204)
-	w.Write([]byte{})
+
+	slog.InfoContext(req.Context(), "replaying webhook event", "admin_id", admin.ID, "webhook_event_id", ledger.ID)
+	finished, _, _ := cfg.runPolkaEvent(req.Context(), ledger)
+	respondWithJSON(w, http.StatusOK, newWebhookEventResponse(finished))
+}
+
+// authenticatePolka requires an HMAC signature once signing secrets are
+// configured. Without them it falls back to the static ApiKey header so
+// existing setups keep working until they move to signed webhooks.
+func (cfg *apiConfig) authenticatePolka(req *http.Request, body []byte) error {
+	if len(cfg.polkaSecrets) > 0 {
+		return auth.VerifyWebhook(
+			cfg.polkaSecrets,
+			req.Header.Get("X-Polka-Timestamp"),
+			req.Header.Get("X-Polka-Signature"),
+			body,
+			time.Now(),
+			polkaWebhookTolerance,
+		)
+	}
+
+	clientAPIKey, err := auth.GetAPIKey(req.Header)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(clientAPIKey), []byte(cfg.polka)) != 1 {
+		return errors.New("key does not match")
+	}
+	return nil
 }
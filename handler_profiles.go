@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+)
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+// publicProfile is everything anyone may see about a user. It never
+// includes the email address.
+type publicProfile struct {
+	ID          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle,omitempty"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	CreatedAt   time.Time `json:"created_at"`
+	ChirpCount  int64     `json:"chirp_count"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+}
+
+func (cfg *apiConfig) handlerUsersGetID(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "invalid user id")
+		return
+	}
+	profile, err := cfg.db.GetPublicProfile(req.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "user cannot be found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, "fetching user failed", err)
+		return
+	}
+	cfg.respondWithPublicProfile(w, req, profile)
+}
+
+func (cfg *apiConfig) handlerUsersGetHandle(w http.ResponseWriter, req *http.Request) {
+	handle := strings.ToLower(req.PathValue("handle"))
+	profile, err := cfg.db.GetPublicProfileByHandle(req.Context(), sql.NullString{String: handle, Valid: true})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "user cannot be found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, "fetching user failed", err)
+		return
+	}
+	cfg.respondWithPublicProfile(w, req, database.GetPublicProfileRow(profile))
+}
+
+// handlerUsersProfileUpdate edits the public profile; fields left out of the
+// body are kept and an empty handle removes it.
+func (cfg *apiConfig) handlerUsersProfileUpdate(w http.ResponseWriter, req *http.Request) {
+	type parameter struct {
+		Handle      *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+	}
+
+	user, _ := authUserFromContext(req.Context())
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+
+	profile, err := cfg.db.GetUserProfile(req.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithServerError(w, "fetching profile failed", err)
+		return
+	}
+
+	update := database.UpsertUserProfileParams{
+		UserID:      user.ID,
+		Handle:      profile.Handle,
+		DisplayName: profile.DisplayName,
+		Bio:         profile.Bio,
+	}
+	if param.Handle != nil {
+		handle := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(*param.Handle), "@"))
+		update.Handle = sql.NullString{String: handle, Valid: handle != ""}
+		if handle != "" && !handlePattern.MatchString(handle) {
+			respondWithError(w, 400, "handle must be 3 to 30 letters, digits or underscores")
+			return
+		}
+	}
+	if param.DisplayName != nil {
+		update.DisplayName = strings.TrimSpace(*param.DisplayName)
+		if utf8.RuneCountInString(update.DisplayName) > maxDisplayNameLength {
+			respondWithError(w, 400, "display name is too long")
+			return
+		}
+	}
+	if param.Bio != nil {
+		update.Bio = strings.TrimSpace(*param.Bio)
+		if utf8.RuneCountInString(update.Bio) > maxBioLength {
+			respondWithError(w, 400, "bio is too long")
+			return
+		}
+	}
+
+	// checks every profile, including those of accounts pending deletion,
+	// which still hold their handle
+	if update.Handle.Valid && update.Handle != profile.Handle {
+		taken, err := cfg.db.GetUserProfileByHandle(req.Context(), update.Handle)
+		if err == nil && taken.UserID != user.ID {
+			respondWithError(w, 409, "handle is already taken")
+			return
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			respondWithServerError(w, "fetching profile failed", err)
+			return
+		}
+	}
+
+	_, err = cfg.db.UpsertUserProfile(req.Context(), update)
+	// a concurrent claim of the same handle can still win the race
+	if isUniqueViolation(err) {
+		respondWithError(w, 409, "handle is already taken")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, "updating profile failed", err)
+		return
+	}
+	updated, err := cfg.db.GetPublicProfile(req.Context(), user.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching user failed", err)
+		return
+	}
+	cfg.respondWithPublicProfile(w, req, updated)
+}
+
+func (cfg *apiConfig) respondWithPublicProfile(w http.ResponseWriter, req *http.Request, row database.GetPublicProfileRow) {
+	isChirpyRed, err := cfg.isChirpyRed(req.Context(), row.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching subscription failed", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, publicProfile{
+		ID:          row.ID,
+		Handle:      row.Handle.String,
+		DisplayName: row.DisplayName.String,
+		Bio:         row.Bio.String,
+		CreatedAt:   row.CreatedAt,
+		ChirpCount:  row.ChirpCount,
+		IsChirpyRed: isChirpyRed,
+	})
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a write that
+// breaks a UNIQUE constraint.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -35,10 +35,16 @@ func (cfg *apiConfig) handlerRefreshToken(w http.ResponseWriter, req *http.Reque
 	}
 	if dbUser.RevokedAt.Valid {
 		respondWithError(w, http.StatusUnauthorized, "refresh token revoked")
+		return
+	}
+	// tokens issued to OAuth clients are refreshed through /api/oauth/token
+	if dbUser.ClientID.Valid || (dbUser.ExpiresAt.Valid && time.Now().After(dbUser.ExpiresAt.Time)) {
+		respondWithError(w, http.StatusUnauthorized, "refresh token expired or does not exist")
+		return
 	}
-	jwtToken, err := auth.MakeJWT(dbUser.UserID, cfg.jwtSecret, time.Hour)
+	jwtToken, err := auth.MakeJWT(dbUser.UserID, cfg.jwtSecret, cfg.accessTokenTTL)
 	if err != nil {
-		respondWithError(w, 500, "access token generation failed")
+		respondWithServerError(w, "access token generation failed", err)
 		return
 	}
 
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/auth"
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/sociallogin"
+)
+
+const oauthStateDuration = 10 * time.Minute
+
+var (
+	errIdentityConflict        = errors.New("an account with this email already exists, log in with your password first")
+	errUnverifiedIdentityEmail = errors.New("identity provider did not return a verified email")
+)
+
+func (cfg *apiConfig) handlerSocialLoginStart(w http.ResponseWriter, req *http.Request) {
+	provider, ok := cfg.socialProviders[req.PathValue("provider")]
+	if !ok {
+		respondWithError(w, 404, "unknown identity provider")
+		return
+	}
+
+	state, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithServerError(w, "state generation failed", err)
+		return
+	}
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithServerError(w, "nonce generation failed", err)
+		return
+	}
+	verifier := sociallogin.NewVerifier()
+
+	err = cfg.db.CreateOAuthState(req.Context(), database.CreateOAuthStateParams{
+		StateHash:    auth.HashToken(state),
+		Provider:     provider.Name,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(oauthStateDuration),
+	})
+	if err != nil {
+		respondWithServerError(w, "saving login state failed", err)
+		return
+	}
+
+	http.Redirect(w, req, provider.AuthCodeURL(state, verifier, nonce), http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerSocialLoginCallback(w http.ResponseWriter, req *http.Request) {
+	provider, ok := cfg.socialProviders[req.PathValue("provider")]
+	if !ok {
+		respondWithError(w, 404, "unknown identity provider")
+		return
+	}
+	query := req.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		respondWithError(w, http.StatusUnauthorized, "identity provider returned "+providerErr)
+		return
+	}
+
+	state, err := cfg.db.ConsumeOAuthState(req.Context(), database.ConsumeOAuthStateParams{
+		StateHash: auth.HashToken(query.Get("state")),
+		Provider:  provider.Name,
+	})
+	if err != nil {
+		respondWithError(w, 400, "login state is invalid or expired")
+		return
+	}
+
+	identity, err := provider.Exchange(req.Context(), query.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		slog.WarnContext(req.Context(), "exchanging authorization code failed", "provider", provider.Name, "error", err)
+		respondWithError(w, http.StatusUnauthorized, "identity provider login failed")
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByIdentity(req.Context(), database.GetUserByIdentityParams{
+		Provider: provider.Name,
+		Subject:  identity.Subject,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		dbUser, err = cfg.linkSocialIdentity(req.Context(), provider.Name, identity)
+	}
+	if errors.Is(err, errIdentityConflict) {
+		respondWithError(w, 409, err.Error())
+		return
+	}
+	if errors.Is(err, errUnverifiedIdentityEmail) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, "fetching user failed", err)
+		return
+	}
+
+	if dbUser.TotpEnabledAt.Valid {
+		cfg.respondWithMFAChallenge(w, req, dbUser)
+		return
+	}
+	cfg.respondWithLogin(w, req, dbUser, "social")
+}
+
+// linkSocialIdentity attaches a first-time identity to the account with the
+// same email, creating the account if there is none. Accounts are only
+// linked when both sides have verified the email address.
+func (cfg *apiConfig) linkSocialIdentity(ctx context.Context, providerName string, identity sociallogin.Identity) (database.User, error) {
+	if identity.Email == "" || !identity.EmailVerified {
+		return database.User{}, errUnverifiedIdentityEmail
+	}
+
+	dbUser, err := cfg.db.GetUserByEmail(ctx, identity.Email)
+	switch {
+	case err == nil:
+		if !dbUser.EmailVerifiedAt.Valid {
+			return database.User{}, errIdentityConflict
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		// social-only accounts get a random password until they reset it
+		password, err := auth.MakeRefreshToken()
+		if err != nil {
+			return database.User{}, err
+		}
+		hashedPass, err := auth.HashPassword(password)
+		if err != nil {
+			return database.User{}, err
+		}
+		dbUser, err = cfg.db.CreateUser(ctx, database.CreateUserParams{
+			Email:          identity.Email,
+			HashedPassword: hashedPass,
+			IsChirpyRed:    sql.NullBool{Bool: false, Valid: false},
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		if err := cfg.db.MarkEmailVerified(ctx, dbUser.ID); err != nil {
+			return database.User{}, err
+		}
+	default:
+		return database.User{}, err
+	}
+
+	err = cfg.db.CreateIdentity(ctx, database.CreateIdentityParams{
+		UserID:   dbUser.ID,
+		Provider: providerName,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	return dbUser, nil
+}
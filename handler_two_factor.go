@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/auth"
+	"github.com/chichigami/chirpy/internal/database"
+)
+
+const (
+	mfaChallengeDuration = 5 * time.Minute
+	recoveryCodeCount    = 10
+)
+
+func (cfg *apiConfig) handlerTwoFactorEnroll(w http.ResponseWriter, req *http.Request) {
+	type response struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}
+	user, _ := authUserFromContext(req.Context())
+
+	dbUser, err := cfg.db.GetUserByID(req.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 404, "user cannot be found")
+		return
+	}
+	if dbUser.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		respondWithServerError(w, "totp secret generation failed", err)
+		return
+	}
+	err = cfg.db.SetTOTPSecret(req.Context(), database.SetTOTPSecretParams{
+		ID:         dbUser.ID,
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+	})
+	if err != nil {
+		respondWithServerError(w, "saving totp secret failed", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret: secret,
+		URI:    auth.TOTPURI(secret, "Chirpy", dbUser.Email),
+	})
+}
+
+func (cfg *apiConfig) handlerTwoFactorConfirm(w http.ResponseWriter, req *http.Request) {
+	type parameter struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	user, _ := authUserFromContext(req.Context())
+
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(req.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 404, "user cannot be found")
+		return
+	}
+	if dbUser.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "two-factor authentication is already enabled")
+		return
+	}
+	if !dbUser.TotpSecret.Valid {
+		respondWithError(w, 400, "two-factor enrollment has not been started")
+		return
+	}
+	ok, err := cfg.useTOTPCode(req.Context(), dbUser, param.Code)
+	if err != nil {
+		respondWithServerError(w, "checking two-factor code failed", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, 400, "invalid two-factor code")
+		return
+	}
+
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithServerError(w, "recovery code generation failed", err)
+		return
+	}
+	if err := cfg.db.DeleteRecoveryCodes(req.Context(), dbUser.ID); err != nil {
+		respondWithServerError(w, "clearing recovery codes failed", err)
+		return
+	}
+	for _, code := range codes {
+		err := cfg.db.CreateRecoveryCode(req.Context(), database.CreateRecoveryCodeParams{
+			UserID:   dbUser.ID,
+			CodeHash: auth.HashToken(code),
+		})
+		if err != nil {
+			respondWithServerError(w, "saving recovery codes failed", err)
+			return
+		}
+	}
+	if err := cfg.db.EnableTOTP(req.Context(), dbUser.ID); err != nil {
+		respondWithServerError(w, "enabling two-factor authentication failed", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+}
+
+func (cfg *apiConfig) handlerLoginTwoFactor(w http.ResponseWriter, req *http.Request) {
+	type parameter struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+
+	userID, err := cfg.db.UseMFAChallenge(req.Context(), auth.HashToken(param.MFAToken))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "mfa token is invalid or expired")
+		return
+	}
+	dbUser, err := cfg.db.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "mfa token is invalid or expired")
+		return
+	}
+
+	if param.RecoveryCode != "" {
+		used, err := cfg.db.UseRecoveryCode(req.Context(), database.UseRecoveryCodeParams{
+			UserID:   dbUser.ID,
+			CodeHash: auth.HashToken(strings.ToLower(strings.TrimSpace(param.RecoveryCode))),
+		})
+		if err != nil || used == 0 {
+			respondWithError(w, http.StatusUnauthorized, "invalid recovery code")
+			return
+		}
+	} else {
+		ok, err := cfg.useTOTPCode(req.Context(), dbUser, param.Code)
+		if err != nil {
+			respondWithServerError(w, "checking two-factor code failed", err)
+			return
+		}
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "invalid two-factor code")
+			return
+		}
+	}
+
+	cfg.respondWithLogin(w, req, dbUser, "two_factor")
+}
+
+// useTOTPCode checks code against the user's TOTP secret and records its
+// time step, refusing a step at or before one already used so an
+// intercepted code can't be replayed while it is still valid.
+func (cfg *apiConfig) useTOTPCode(ctx context.Context, dbUser database.User, code string) (bool, error) {
+	step, ok := auth.MatchTOTP(dbUser.TotpSecret.String, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	used, err := cfg.db.UseTOTPStep(ctx, database.UseTOTPStepParams{UserID: dbUser.ID, Step: step})
+	if err != nil {
+		return false, err
+	}
+	return used == 1, nil
+}
+
+// respondWithMFAChallenge replaces the login tokens with a single-use
+// challenge token that must be exchanged at /api/login/2fa.
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, req *http.Request, dbUser database.User) {
+	type response struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithServerError(w, "mfa token generation failed", err)
+		return
+	}
+	err = cfg.db.CreateMFAChallenge(req.Context(), database.CreateMFAChallengeParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    dbUser.ID,
+		ExpiresAt: time.Now().Add(mfaChallengeDuration),
+	})
+	if err != nil {
+		respondWithServerError(w, "mfa challenge creation db error", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		MFARequired: true,
+		MFAToken:    token,
+	})
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -11,45 +14,86 @@ import (
 	"github.com/google/uuid"
 )
 
+// handlerUsersUpdate changes the email and/or password; fields left out of
+// the body are kept. Both changes need the current password, and a new
+// email only replaces the old one once it has been verified.
 func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, req *http.Request) {
-	jwtToken, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		respondWithError(w, 401, err.Error())
-		return
+	type parameter struct {
+		CurrentPassword string  `json:"current_password"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
 	}
-	userID, err := auth.ValidateJWT(jwtToken, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, 401, err.Error())
-		return
+	type User struct {
+		ID            uuid.UUID `json:"id"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
+		Email         string    `json:"email"`
+		PendingEmail  string    `json:"pending_email,omitempty"`
+		Is_Chirpy_Red bool      `json:"is_chirpy_red"`
 	}
 
+	user, _ := authUserFromContext(req.Context())
 	param := parameter{}
 	decoder := json.NewDecoder(req.Body)
 	if err := decoder.Decode(&param); err != nil {
 		respondWithError(w, 400, err.Error())
 		return
 	}
+	if param.Email == nil && param.Password == nil {
+		respondWithError(w, 400, "nothing to update")
+		return
+	}
 
-	newPassword, err := auth.HashPassword(param.Password)
+	dbUser, err := cfg.db.GetUserByID(req.Context(), user.ID)
 	if err != nil {
-		respondWithError(w, 500, err.Error())
+		respondWithError(w, 404, "user cannot be found")
 		return
 	}
-	cfg.db.UpdateEmailandPassword(req.Context(), database.UpdateEmailandPasswordParams{
-		ID:             userID,
-		Email:          param.Email,
-		HashedPassword: newPassword,
-	})
-	type User struct {
-		ID            uuid.UUID `json:"id"`
-		CreatedAt     time.Time `json:"created_at"`
-		UpdatedAt     time.Time `json:"updated_at"`
-		Email         string    `json:"email"`
-		Is_Chirpy_Red bool      `json:"is_chirpy_red"`
+	if !cfg.reauthenticate(w, req, dbUser, param.CurrentPassword) {
+		return
 	}
-	dbUser, err := cfg.db.GetUserByEmail(req.Context(), param.Email)
+
+	newEmail := ""
+	if param.Email != nil && *param.Email != dbUser.Email {
+		newEmail = *param.Email
+		if newEmail == "" {
+			respondWithError(w, 400, "email cannot be empty")
+			return
+		}
+		_, err := cfg.db.GetUserByEmail(req.Context(), newEmail)
+		if err == nil {
+			respondWithError(w, 409, "email is already in use")
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			respondWithServerError(w, "fetching user failed", err)
+			return
+		}
+	}
+	if param.Password != nil && !cfg.validatePassword(w, *param.Password, dbUser.Email, newEmail) {
+		return
+	}
+
+	if param.Password != nil {
+		if !cfg.changePassword(w, req, dbUser, *param.Password) {
+			return
+		}
+	}
+	if newEmail != "" {
+		if err := cfg.sendEmailChange(req.Context(), dbUser, newEmail); err != nil {
+			respondWithServerError(w, "sending email change confirmation failed", err)
+			return
+		}
+	}
+
+	dbUser, err = cfg.db.GetUserByID(req.Context(), user.ID)
 	if err != nil {
-		respondWithError(w, 500, "fetching user failed")
+		respondWithServerError(w, "fetching user failed", err)
+		return
+	}
+	isChirpyRed, err := cfg.isChirpyRed(req.Context(), user.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching subscription failed", err)
 		return
 	}
 	respondWithJSON(w, 200, User{
@@ -57,58 +101,103 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, req *http.Reques
 		CreatedAt:     dbUser.CreatedAt,
 		UpdatedAt:     dbUser.UpdatedAt,
 		Email:         dbUser.Email,
-		Is_Chirpy_Red: dbUser.IsChirpyRed.Bool,
+		PendingEmail:  newEmail,
+		Is_Chirpy_Red: isChirpyRed,
 	})
 }
 
 func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, req *http.Request) {
-	type User struct {
-		ID            uuid.UUID `json:"id"`
-		CreatedAt     time.Time `json:"created_at"`
-		UpdatedAt     time.Time `json:"updated_at"`
-		Email         string    `json:"email"`
-		Token         string    `json:"token"`
-		Refresh_Token string    `json:"refresh_token"`
-		Is_Chirpy_Red bool      `json:"is_chirpy_red"`
-	}
 	param := parameter{}
 	decoder := json.NewDecoder(req.Body)
 	if decodeErr := decoder.Decode(&param); decodeErr != nil {
-		respondWithError(w, 500, decodeErr.Error())
+		respondWithServerError(w, decodeErr.Error(), decodeErr)
+		return
+	}
+
+	attempts := []*loginAttempt{
+		{key: accountThrottleKey(param.Email), policy: accountThrottle},
+		{key: ipThrottleKey(req), policy: ipThrottle},
+	}
+	wait, err := cfg.claimLoginAttempts(req.Context(), attempts...)
+	if err != nil {
+		respondWithServerError(w, "checking login throttle failed", err)
+		return
+	}
+	if wait > 0 {
+		respondTooManyLoginAttempts(w, wait)
 		return
 	}
 
 	dbUser, err := cfg.db.GetUserByEmail(req.Context(), param.Email)
+	if err == nil {
+		err = auth.CheckPasswordHash(dbUser.HashedPassword, param.Password)
+	}
 	if err != nil {
+		cfg.loginFailed(req.Context(), attempts...)
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
 		return
 	}
-	if err := auth.CheckPasswordHash(dbUser.HashedPassword, param.Password); err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+	cfg.loginSucceeded(req.Context(), attempts...)
+
+	if auth.NeedsRehash(dbUser.HashedPassword) {
+		cfg.rehashPassword(req.Context(), dbUser, param.Password)
+	}
+
+	if dbUser.TotpEnabledAt.Valid {
+		cfg.respondWithMFAChallenge(w, req, dbUser)
 		return
 	}
+	cfg.respondWithLogin(w, req, dbUser, "password")
+}
+
+// respondWithLogin issues a fresh access and refresh token pair for a user
+// who has fully authenticated with method.
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, req *http.Request, dbUser database.User, method string) {
+	type User struct {
+		ID            uuid.UUID `json:"id"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
+		Email         string    `json:"email"`
+		Token         string    `json:"token"`
+		Refresh_Token string    `json:"refresh_token"`
+		Is_Chirpy_Red bool      `json:"is_chirpy_red"`
+	}
+
+	// logging in during the grace period keeps the account
+	if dbUser.DeletionRequestedAt.Valid {
+		if err := cfg.db.CancelUserDeletion(req.Context(), dbUser.ID); err != nil {
+			respondWithServerError(w, "restoring account failed", err)
+			return
+		}
+		slog.InfoContext(req.Context(), "cancelled pending account deletion after login", "user_id", dbUser.ID)
+	}
 
-	const maxTokenDuration = time.Hour
+	isChirpyRed, err := cfg.isChirpyRed(req.Context(), dbUser.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching subscription failed", err)
+		return
+	}
 
-	userToken, err := auth.MakeJWT(dbUser.ID, cfg.jwtSecret, maxTokenDuration)
+	userToken, err := auth.MakeJWT(dbUser.ID, cfg.jwtSecret, cfg.accessTokenTTL)
 	if err != nil {
-		respondWithError(w, 500, "access token generation failed")
+		respondWithServerError(w, "access token generation failed", err)
 		return
 	}
 
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, 500, "refresh token generation failed")
+		respondWithServerError(w, "refresh token generation failed", err)
 		return
 	}
 	refreshTokenParam := database.CreateRefreshTokenParams{
 		Token:     refreshToken,
 		UserID:    dbUser.ID,
-		ExpiresAt: sql.NullTime{Time: time.Now().Add(time.Hour * 24 * 60), Valid: true},
+		ExpiresAt: sql.NullTime{Time: time.Now().Add(cfg.refreshTokenTTL), Valid: true},
 		RevokedAt: sql.NullTime{},
 	}
 	cfg.db.CreateRefreshToken(req.Context(), refreshTokenParam)
 
+	cfg.metrics.logins.WithLabelValues(method).Inc()
 	respondWithJSON(w, http.StatusOK, User{
 		dbUser.ID,
 		dbUser.CreatedAt,
@@ -116,7 +205,7 @@ func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, req *http.Request
 		dbUser.Email,
 		userToken,
 		refreshToken,
-		dbUser.IsChirpyRed.Bool,
+		isChirpyRed,
 	})
 }
 
@@ -135,9 +224,12 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, req *http.Reques
 		respondWithError(w, 400, decodeErr.Error())
 		return
 	}
+	if !cfg.validatePassword(w, param.Password, param.Email) {
+		return
+	}
 	hashedPass, err := auth.HashPassword(param.Password)
 	if err != nil {
-		respondWithError(w, 500, "failed to hash password")
+		respondWithServerError(w, "failed to hash password", err)
 		return
 	}
 
@@ -148,10 +240,14 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, req *http.Reques
 	})
 
 	if dbErr != nil {
-		respondWithError(w, http.StatusInternalServerError, dbErr.Error())
+		respondWithServerError(w, dbErr.Error(), dbErr)
 		return
 	}
 
+	if err := cfg.sendEmailVerification(req.Context(), dbUser); err != nil {
+		slog.ErrorContext(req.Context(), "sending verification email failed", "user_id", dbUser.ID, "error", err)
+	}
+
 	respondWithJSON(w, http.StatusCreated, User{
 		ID:            dbUser.ID,
 		CreatedAt:     dbUser.CreatedAt,
@@ -161,6 +257,39 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, req *http.Reques
 	})
 }
 
+// rehashPassword upgrades a stored hash after a successful login, while the
+// plaintext password is still at hand.
+func (cfg *apiConfig) rehashPassword(ctx context.Context, dbUser database.User, password string) {
+	hashedPass, err := auth.HashPassword(password)
+	if err != nil {
+		slog.ErrorContext(ctx, "rehashing password failed", "user_id", dbUser.ID, "error", err)
+		return
+	}
+	err = cfg.db.UpdatePassword(ctx, database.UpdatePasswordParams{
+		ID:             dbUser.ID,
+		HashedPassword: hashedPass,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "saving rehashed password failed", "user_id", dbUser.ID, "error", err)
+	}
+}
+
+// validatePassword checks password against the configured policy and writes
+// the validation problems to w when it fails.
+func (cfg *apiConfig) validatePassword(w http.ResponseWriter, password string, userInputs ...string) bool {
+	err := cfg.passwordPolicy.Validate(password, userInputs...)
+	policyErr := &auth.PasswordPolicyError{}
+	if errors.As(err, &policyErr) {
+		respondWithValidationError(w, "password does not meet policy", policyErr.Problems)
+		return false
+	}
+	if err != nil {
+		respondWithServerError(w, "checking password failed", err)
+		return false
+	}
+	return true
+}
+
 type parameter struct {
 	Password string `json:"password"`
 	Email    string `json:"email"`
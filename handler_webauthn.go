@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/passkey"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const (
+	webauthnSessionDuration = 5 * time.Minute
+	ceremonyRegistration    = "registration"
+	ceremonyLogin           = "login"
+)
+
+func (cfg *apiConfig) handlerWebauthnRegisterBegin(w http.ResponseWriter, req *http.Request) {
+	type response struct {
+		SessionID uuid.UUID                    `json:"session_id"`
+		Options   *protocol.CredentialCreation `json:"options"`
+	}
+	user, _ := authUserFromContext(req.Context())
+
+	pkUser, err := cfg.loadPasskeyUser(req.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 404, "user cannot be found")
+		return
+	}
+
+	exclusions := []protocol.CredentialDescriptor{}
+	for _, credential := range pkUser.Credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	creation, session, err := cfg.webAuthn.BeginRegistration(pkUser, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		respondWithServerError(w, "starting passkey registration failed", err)
+		return
+	}
+
+	sessionID, err := cfg.saveWebauthnSession(req.Context(), uuid.NullUUID{UUID: user.ID, Valid: true}, ceremonyRegistration, session)
+	if err != nil {
+		respondWithServerError(w, "saving passkey session failed", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		SessionID: sessionID,
+		Options:   creation,
+	})
+}
+
+func (cfg *apiConfig) handlerWebauthnRegisterFinish(w http.ResponseWriter, req *http.Request) {
+	type response struct {
+		ID string `json:"id"`
+	}
+	user, _ := authUserFromContext(req.Context())
+
+	session, err := cfg.consumeWebauthnSession(req.Context(), req.PathValue("sessionID"), ceremonyRegistration)
+	if err != nil || !session.UserID.Valid || session.UserID.UUID != user.ID {
+		respondWithError(w, 400, "passkey session is invalid or expired")
+		return
+	}
+	sessionData := webauthn.SessionData{}
+	if err := json.Unmarshal(session.SessionData, &sessionData); err != nil {
+		respondWithServerError(w, "reading passkey session failed", err)
+		return
+	}
+
+	pkUser, err := cfg.loadPasskeyUser(req.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 404, "user cannot be found")
+		return
+	}
+	credential, err := cfg.webAuthn.FinishRegistration(pkUser, sessionData, req)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	if err := cfg.db.CreateWebauthnCredential(req.Context(), passkey.CreateCredentialParams(user.ID, credential)); err != nil {
+		respondWithServerError(w, "saving passkey failed", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{
+		ID: base64.RawURLEncoding.EncodeToString(credential.ID),
+	})
+}
+
+func (cfg *apiConfig) handlerWebauthnLoginBegin(w http.ResponseWriter, req *http.Request) {
+	type response struct {
+		SessionID uuid.UUID                     `json:"session_id"`
+		Options   *protocol.CredentialAssertion `json:"options"`
+	}
+
+	assertion, session, err := cfg.webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		respondWithServerError(w, "starting passkey login failed", err)
+		return
+	}
+
+	sessionID, err := cfg.saveWebauthnSession(req.Context(), uuid.NullUUID{}, ceremonyLogin, session)
+	if err != nil {
+		respondWithServerError(w, "saving passkey session failed", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		SessionID: sessionID,
+		Options:   assertion,
+	})
+}
+
+func (cfg *apiConfig) handlerWebauthnLoginFinish(w http.ResponseWriter, req *http.Request) {
+	session, err := cfg.consumeWebauthnSession(req.Context(), req.PathValue("sessionID"), ceremonyLogin)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "passkey session is invalid or expired")
+		return
+	}
+	sessionData := webauthn.SessionData{}
+	if err := json.Unmarshal(session.SessionData, &sessionData); err != nil {
+		respondWithServerError(w, "reading passkey session failed", err)
+		return
+	}
+
+	var dbUser database.User
+	credential, err := cfg.webAuthn.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := passkey.UserIDFromHandle(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		dbUser, err = cfg.db.GetUserByID(req.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+		dbCredentials, err := cfg.db.ListWebauthnCredentials(req.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+		return passkey.NewUser(dbUser, dbCredentials), nil
+	}, sessionData, req)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "passkey login failed")
+		return
+	}
+
+	if err := passkey.CheckSignCount(credential); err != nil {
+		slog.WarnContext(req.Context(), "rejected passkey login", "user_id", dbUser.ID, "error", err)
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	err = cfg.db.UpdateWebauthnSignCount(req.Context(), database.UpdateWebauthnSignCountParams{
+		ID:        credential.ID,
+		SignCount: int64(credential.Authenticator.SignCount),
+	})
+	if err != nil {
+		respondWithServerError(w, "updating passkey failed", err)
+		return
+	}
+
+	cfg.respondWithLogin(w, req, dbUser, "passkey")
+}
+
+func (cfg *apiConfig) loadPasskeyUser(ctx context.Context, userID uuid.UUID) (*passkey.User, error) {
+	dbUser, err := cfg.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	dbCredentials, err := cfg.db.ListWebauthnCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return passkey.NewUser(dbUser, dbCredentials), nil
+}
+
+func (cfg *apiConfig) saveWebauthnSession(ctx context.Context, userID uuid.NullUUID, ceremony string, session *webauthn.SessionData) (uuid.UUID, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return cfg.db.CreateWebauthnSession(ctx, database.CreateWebauthnSessionParams{
+		UserID:      userID,
+		Ceremony:    ceremony,
+		SessionData: data,
+		ExpiresAt:   time.Now().Add(webauthnSessionDuration),
+	})
+}
+
+// consumeWebauthnSession deletes the session as it reads it so each
+// challenge can only be answered once.
+func (cfg *apiConfig) consumeWebauthnSession(ctx context.Context, rawID, ceremony string) (database.WebauthnSession, error) {
+	sessionID, err := uuid.Parse(rawID)
+	if err != nil {
+		return database.WebauthnSession{}, err
+	}
+	return cfg.db.ConsumeWebauthnSession(ctx, database.ConsumeWebauthnSessionParams{
+		ID:       sessionID,
+		Ceremony: ceremony,
+	})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/auth"
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+type webhookEndpointResponse struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Global     bool      `json:"global"`
+	Active     bool      `json:"active"`
+	Secret     string    `json:"secret,omitempty"`
+}
+
+type webhookDeliveryResponse struct {
+	ID             uuid.UUID  `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	EventType      string     `json:"event_type"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"`
+	Attempts       int32      `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	LastStatusCode *int32     `json:"last_status_code"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+type webhookAttemptResponse struct {
+	CreatedAt  time.Time `json:"created_at"`
+	StatusCode *int32    `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int32     `json:"duration_ms"`
+}
+
+// handlerWebhookEndpointsCreate registers an endpoint for the caller, or a
+// global one that receives every user's events when an admin asks for it.
+// The signing secret is only ever shown in this response.
+func (cfg *apiConfig) handlerWebhookEndpointsCreate(w http.ResponseWriter, req *http.Request) {
+	type parameter struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+		Global     bool     `json:"global"`
+	}
+
+	user, _ := authUserFromContext(req.Context())
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+
+	if err := webhooks.ValidateURL(param.URL, cfg.platform == "dev"); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if len(param.EventTypes) == 0 {
+		respondWithError(w, 400, "event_types must not be empty")
+		return
+	}
+	for _, eventType := range param.EventTypes {
+		if !slices.Contains(webhooks.EventTypes, eventType) {
+			respondWithError(w, 400, "event_types must be from "+strings.Join(webhooks.EventTypes, ", "))
+			return
+		}
+	}
+	if param.Global {
+		isAdmin, err := cfg.isAdmin(req, user.ID)
+		if err != nil {
+			respondWithServerError(w, "fetching role failed", err)
+			return
+		}
+		if !isAdmin {
+			respondWithError(w, http.StatusForbidden, "requires the "+roleAdmin+" role")
+			return
+		}
+	}
+
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithServerError(w, "generating secret failed", err)
+		return
+	}
+	owner := uuid.NullUUID{UUID: user.ID, Valid: !param.Global}
+	endpoint, err := cfg.db.CreateWebhookEndpoint(req.Context(), database.CreateWebhookEndpointParams{
+		UserID:     owner,
+		Url:        param.URL,
+		Secret:     secret,
+		EventTypes: strings.Join(slices.Compact(slices.Sorted(slices.Values(param.EventTypes))), " "),
+	})
+	if err != nil {
+		respondWithServerError(w, "creating webhook endpoint failed", err)
+		return
+	}
+	response := newWebhookEndpointResponse(endpoint)
+	response.Secret = endpoint.Secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointsList(w http.ResponseWriter, req *http.Request) {
+	user, _ := authUserFromContext(req.Context())
+	isAdmin, err := cfg.isAdmin(req, user.ID)
+	if err != nil {
+		respondWithServerError(w, "fetching role failed", err)
+		return
+	}
+	endpoints, err := cfg.db.ListWebhookEndpoints(req.Context(), database.ListWebhookEndpointsParams{
+		UserID:        uuid.NullUUID{UUID: user.ID, Valid: true},
+		IncludeGlobal: isAdmin,
+	})
+	if err != nil {
+		respondWithServerError(w, "fetching webhook endpoints failed", err)
+		return
+	}
+	response := []webhookEndpointResponse{}
+	for _, endpoint := range endpoints {
+		response = append(response, newWebhookEndpointResponse(endpoint))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerWebhookEndpointsDelete(w http.ResponseWriter, req *http.Request) {
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, req)
+	if !ok {
+		return
+	}
+	if err := cfg.db.DeleteWebhookEndpoint(req.Context(), endpoint.ID); err != nil {
+		respondWithServerError(w, "deleting webhook endpoint failed", err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerWebhookDeliveriesList(w http.ResponseWriter, req *http.Request) {
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, req)
+	if !ok {
+		return
+	}
+	limit := 50
+	if limitParam := req.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 1 || parsed > 500 {
+			respondWithError(w, 400, "limit must be between 1 and 500")
+			return
+		}
+		limit = parsed
+	}
+
+	deliveries, err := cfg.db.ListWebhookDeliveries(req.Context(), database.ListWebhookDeliveriesParams{
+		EndpointID: endpoint.ID,
+		Limit:      int32(limit),
+	})
+	if err != nil {
+		respondWithServerError(w, "fetching webhook deliveries failed", err)
+		return
+	}
+	response := []webhookDeliveryResponse{}
+	for _, delivery := range deliveries {
+		item := webhookDeliveryResponse{
+			ID:             delivery.ID,
+			CreatedAt:      delivery.CreatedAt,
+			EventType:      delivery.EventType,
+			Payload:        delivery.Payload,
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			LastAttemptAt:  nullTimePtr(delivery.LastAttemptAt),
+			LastStatusCode: nullInt32Ptr(delivery.LastStatusCode),
+			LastError:      delivery.LastError,
+		}
+		if delivery.Status == "pending" {
+			item.NextAttemptAt = &delivery.NextAttemptAt
+		}
+		response = append(response, item)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerWebhookAttemptsList(w http.ResponseWriter, req *http.Request) {
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, req)
+	if !ok {
+		return
+	}
+	deliveryID, err := uuid.Parse(req.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, 404, "invalid delivery id")
+		return
+	}
+
+	attempts, err := cfg.db.ListWebhookDeliveryAttempts(req.Context(), database.ListWebhookDeliveryAttemptsParams{
+		DeliveryID: deliveryID,
+		EndpointID: endpoint.ID,
+	})
+	if err != nil {
+		respondWithServerError(w, "fetching delivery attempts failed", err)
+		return
+	}
+	response := []webhookAttemptResponse{}
+	for _, attempt := range attempts {
+		response = append(response, webhookAttemptResponse{
+			CreatedAt:  attempt.CreatedAt,
+			StatusCode: nullInt32Ptr(attempt.StatusCode),
+			Error:      attempt.Error,
+			DurationMs: attempt.DurationMs,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// ownedWebhookEndpoint loads the endpoint named in the path if the caller
+// owns it, or if it is global and the caller is an admin. Anything else is
+// a 404 so endpoint ids can't be probed.
+func (cfg *apiConfig) ownedWebhookEndpoint(w http.ResponseWriter, req *http.Request) (database.WebhookEndpoint, bool) {
+	user, _ := authUserFromContext(req.Context())
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		respondWithError(w, 404, "invalid webhook endpoint id")
+		return database.WebhookEndpoint{}, false
+	}
+	endpoint, err := cfg.db.GetWebhookEndpoint(req.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithServerError(w, "fetching webhook endpoint failed", err)
+		return database.WebhookEndpoint{}, false
+	}
+
+	allowed := err == nil && endpoint.UserID.Valid && endpoint.UserID.UUID == user.ID
+	if err == nil && !endpoint.UserID.Valid {
+		isAdmin, err := cfg.isAdmin(req, user.ID)
+		if err != nil {
+			respondWithServerError(w, "fetching role failed", err)
+			return database.WebhookEndpoint{}, false
+		}
+		allowed = isAdmin
+	}
+	if !allowed {
+		respondWithError(w, 404, "webhook endpoint cannot be found")
+		return database.WebhookEndpoint{}, false
+	}
+	return endpoint, true
+}
+
+func (cfg *apiConfig) isAdmin(req *http.Request, userID uuid.UUID) (bool, error) {
+	role, err := cfg.db.GetUserRole(req.Context(), userID)
+	if err != nil {
+		return false, err
+	}
+	return role == roleAdmin, nil
+}
+
+func newWebhookEndpointResponse(endpoint database.WebhookEndpoint) webhookEndpointResponse {
+	return webhookEndpointResponse{
+		ID:         endpoint.ID,
+		CreatedAt:  endpoint.CreatedAt,
+		URL:        endpoint.Url,
+		EventTypes: strings.Fields(endpoint.EventTypes),
+		Global:     !endpoint.UserID.Valid,
+		Active:     endpoint.Active,
+	}
+}
+
+func nullInt32Ptr(n sql.NullInt32) *int32 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int32
+}
@@ -2,7 +2,11 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,6 +14,7 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -34,6 +39,12 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// HashToken digests an opaque token so only the hash needs to be stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetBearerToken(headers http.Header) (string, error) {
 	authInfo := headers.Get("Authorization")
 	tokenParts := strings.Split(authInfo, " ")
@@ -43,39 +54,66 @@ func GetBearerToken(headers http.Header) (string, error) {
 	return tokenParts[1], nil
 }
 
+// Claims are the access token claims. Tokens issued to third-party OAuth
+// clients carry the client and the scopes the user granted it; first-party
+// tokens leave both empty.
+type Claims struct {
+	jwt.RegisteredClaims
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	jwtToken, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+	claims, err := ParseJWT(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return userID, nil
+}
+
+// ParseJWT validates an access token and returns all of its claims.
+func ParseJWT(tokenString, tokenSecret string) (*Claims, error) {
+	jwtToken, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(tokenSecret), nil
 	})
 	if err != nil {
-		return uuid.UUID{}, err
+		return nil, err
 	}
 
-	jwtClaims, ok := jwtToken.Claims.(*jwt.RegisteredClaims)
+	jwtClaims, ok := jwtToken.Claims.(*Claims)
 	if !ok {
-		return uuid.UUID{}, fmt.Errorf("invalid token claims")
+		return nil, fmt.Errorf("invalid token claims")
 	}
 
-	if time.Now().After(jwtClaims.ExpiresAt.Time) {
-		return uuid.UUID{}, fmt.Errorf("jwt token expired")
+	if jwtClaims.ExpiresAt == nil || time.Now().After(jwtClaims.ExpiresAt.Time) {
+		return nil, fmt.Errorf("jwt token expired")
 	}
-
-	userID, err := uuid.Parse(jwtClaims.Subject)
-	if err != nil {
-		return uuid.UUID{}, err
-	}
-	return userID, nil
+	return jwtClaims, nil
 }
 
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn).UTC()),
-		Subject:   userID.String(),
+	return MakeClientJWT(userID, tokenSecret, expiresIn, "", "")
+}
+
+// MakeClientJWT issues an access token on behalf of an OAuth client, limited
+// to the space-separated scope the user approved.
+func MakeClientJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration, clientID, scope string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn).UTC()),
+			Subject:   userID.String(),
+		},
+		ClientID: clientID,
+		Scope:    scope,
 	})
 	signedToken, err := token.SignedString([]byte(tokenSecret))
 	if err != nil {
@@ -84,14 +122,120 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 	return signedToken, nil
 }
 
+// VerifyPKCE checks an RFC 7636 code verifier against the S256 challenge the
+// client sent with its authorization request.
+func VerifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// Argon2Params are the argon2id settings encoded into every hash so they can
+// be raised later without breaking existing passwords.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP password storage recommendation.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var errMismatchedHashAndPassword = errors.New("hashedPassword is not the hash of the given password")
+
+// CheckPasswordHash verifies password against an argon2id hash, falling back
+// to bcrypt for hashes stored before argon2id became the default.
 func CheckPasswordHash(hash, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	}
+
+	params, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return err
+	}
+	actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return errMismatchedHashAndPassword
+	}
+	return nil
 }
 
 func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	params := DefaultArgon2Params
+	salt := make([]byte, params.SaltLength)
+	_, err := rand.Read(salt)
 	if err != nil {
 		return "", err
 	}
-	return string(hash), nil
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// NeedsRehash reports whether hash was made with an older algorithm or
+// weaker parameters than HashPassword currently uses. Hashes made with
+// stronger parameters are kept rather than rehashed down to the defaults.
+func NeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		return true
+	}
+	params, _, _, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	defaults := DefaultArgon2Params
+	return params.Memory < defaults.Memory ||
+		params.Iterations < defaults.Iterations ||
+		params.Parallelism < defaults.Parallelism ||
+		params.SaltLength < defaults.SaltLength ||
+		params.KeyLength < defaults.KeyLength
+}
+
+func decodeArgon2Hash(hash string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	if version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	params := Argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
 }
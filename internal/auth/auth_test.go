@@ -2,7 +2,12 @@ package auth
 
 import (
 	"net/http"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestHashPassword(t *testing.T) {
@@ -13,6 +18,42 @@ func TestHashPassword(t *testing.T) {
 	}
 }
 
+func TestCheckPasswordHashBcrypt(t *testing.T) {
+	password := "Hello World"
+	legacy, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := CheckPasswordHash(string(legacy), password); err != nil {
+		t.Fatalf("bcrypt hash should still verify")
+	}
+	if err := CheckPasswordHash(string(legacy), "wrong"); err == nil {
+		t.Fatalf("wrong password should not verify")
+	}
+	if !NeedsRehash(string(legacy)) {
+		t.Fatalf("bcrypt hash should need a rehash")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	current, _ := HashPassword("Hello World")
+	if NeedsRehash(current) {
+		t.Fatalf("fresh hash should not need a rehash")
+	}
+	if err := CheckPasswordHash(current, "wrong"); err == nil {
+		t.Fatalf("wrong password should not verify")
+	}
+	weaker := strings.Replace(current, "t=2", "t=1", 1)
+	if !NeedsRehash(weaker) {
+		t.Fatalf("hash with fewer iterations should need a rehash")
+	}
+	lessMemory := strings.Replace(current, "m=19456", "m=8192", 1)
+	if !NeedsRehash(lessMemory) {
+		t.Fatalf("hash with less memory should need a rehash")
+	}
+	stronger := strings.Replace(strings.Replace(current, "t=2", "t=4", 1), "m=19456", "m=65536", 1)
+	if NeedsRehash(stronger) {
+		t.Fatalf("hash with stronger parameters should not be rehashed down")
+	}
+}
+
 func TestGetBearerToken(t *testing.T) {
 	headers := http.Header{}
 	headers.Set("Authorization", "Bearer 123")
@@ -40,3 +81,45 @@ func TestMakeRefreshToken(t *testing.T) {
 		t.Fatalf("token: %v is not %v long, it's %v", token, expectedLength, len(token))
 	}
 }
+
+func TestHashToken(t *testing.T) {
+	if HashToken("abc") != HashToken("abc") {
+		t.Fatalf("hashing the same token twice should match")
+	}
+	if HashToken("abc") == HashToken("abd") {
+		t.Fatalf("different tokens should not share a hash")
+	}
+}
+
+func TestMakeClientJWT(t *testing.T) {
+	userID := uuid.New()
+	token, err := MakeClientJWT(userID, "secret", time.Minute, "client-1", "chirps:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseJWT(token, "secret")
+	if err != nil {
+		t.Fatalf("token should validate: %v", err)
+	}
+	if claims.Subject != userID.String() || claims.ClientID != "client-1" || claims.Scope != "chirps:read" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+	if _, err := ParseJWT(token, "other"); err == nil {
+		t.Fatalf("token signed with another secret should not validate")
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	// example from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if !VerifyPKCE(verifier, challenge) {
+		t.Fatalf("RFC 7636 example should verify")
+	}
+	if VerifyPKCE(verifier[:42], challenge) {
+		t.Fatalf("short verifier should not verify")
+	}
+	if VerifyPKCE(strings.Repeat("a", 43), challenge) {
+		t.Fatalf("wrong verifier should not verify")
+	}
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcrypt silently ignores everything past 72 bytes
+const MaxPasswordBytes = 72
+
+type PasswordProblem struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type PasswordPolicyError struct {
+	Problems []PasswordProblem
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	// MinStrength is the lowest acceptable EstimateStrength score, 0 to 4.
+	MinStrength int
+	// Breached is optional; when nil passwords are not checked against it.
+	Breached *BreachedPasswords
+}
+
+// Validate reports every way password falls short of the policy at once as a
+// *PasswordPolicyError. userInputs are things like the email address that
+// should not make a password look stronger than it is.
+func (p PasswordPolicy) Validate(password string, userInputs ...string) error {
+	problems := []PasswordProblem{}
+
+	if len([]rune(password)) < p.MinLength {
+		problems = append(problems, PasswordProblem{
+			Code:    "too_short",
+			Message: fmt.Sprintf("password must be at least %d characters", p.MinLength),
+		})
+	}
+	if len(password) > MaxPasswordBytes {
+		problems = append(problems, PasswordProblem{
+			Code:    "too_long",
+			Message: fmt.Sprintf("password must be at most %d bytes", MaxPasswordBytes),
+		})
+	}
+	if password != "" && EstimateStrength(password, userInputs...) < p.MinStrength {
+		problems = append(problems, PasswordProblem{
+			Code:    "too_weak",
+			Message: "password is too easy to guess",
+		})
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			problems = append(problems, PasswordProblem{
+				Code:    "breached",
+				Message: "password has appeared in a data breach",
+			})
+		}
+	}
+
+	if len(problems) > 0 {
+		return &PasswordPolicyError{Problems: problems}
+	}
+	return nil
+}
+
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "123456": true,
+	"12345678": true, "123456789": true, "1234567890": true, "qwerty": true,
+	"qwertyuiop": true, "abc123": true, "111111": true, "letmein": true,
+	"welcome": true, "iloveyou": true, "admin": true, "monkey": true,
+	"dragon": true, "football": true, "baseball": true, "sunshine": true,
+	"princess": true, "trustno1": true, "passw0rd": true, "chirpy": true,
+}
+
+// EstimateStrength scores a password from 0 (trivially guessable) to 4 (very
+// hard to guess) in the spirit of zxcvbn: common passwords, personal info,
+// repeats and sequences count for little; the rest is scored by an estimate
+// of the guesses needed to find it.
+func EstimateStrength(password string, userInputs ...string) int {
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return 0
+	}
+
+	var hasLower, hasUpper, hasDigit, hasSymbol, hasOther bool
+	effectiveLength := 0.0
+	var prev rune = -1
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			hasLower = true
+		case r >= 'A' && r <= 'Z':
+			hasUpper = true
+		case r >= '0' && r <= '9':
+			hasDigit = true
+		case r < unicode.MaxASCII:
+			hasSymbol = true
+		default:
+			hasOther = true
+		}
+		if prev >= 0 && (r == prev || r == prev+1 || r == prev-1) {
+			effectiveLength += 0.25
+		} else {
+			effectiveLength++
+		}
+		prev = r
+	}
+	// personal info is about as guessable as a single character
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		if len(input) >= 3 && strings.Contains(lower, input) {
+			effectiveLength -= float64(utf8.RuneCountInString(input) - 1)
+		}
+	}
+	effectiveLength = max(effectiveLength, 0)
+
+	pool := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{hasLower, 26}, {hasUpper, 26}, {hasDigit, 10}, {hasSymbol, 33}, {hasOther, 100}} {
+		if class.present {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	log10Guesses := effectiveLength * math.Log10(float64(pool))
+	switch {
+	case log10Guesses < 3:
+		return 0
+	case log10Guesses < 6:
+		return 1
+	case log10Guesses < 8:
+		return 2
+	case log10Guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// BreachedPasswords checks passwords against an offline copy of a
+// k-anonymity range dataset: Dir holds one file per 5 character SHA-1 prefix,
+// each line being the remaining 35 characters of a hash, optionally followed
+// by ":count", the same layout the Pwned Passwords range API serves.
+type BreachedPasswords struct {
+	Dir string
+}
+
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(b.Dir, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEstimateStrength(t *testing.T) {
+	weak := []string{"password", "aaaaaaaa", "abcdefgh", "bob@example.com12"}
+	for _, password := range weak {
+		if score := EstimateStrength(password, "bob@example.com"); score >= 2 {
+			t.Fatalf("%q scored %d, expected a weak score", password, score)
+		}
+	}
+
+	strong := []string{"correct horse battery", "Tr0ub4dor&3", "a-longer-passphrase-here"}
+	for _, password := range strong {
+		if score := EstimateStrength(password, "bob@example.com"); score < 3 {
+			t.Fatalf("%q scored %d, expected a strong score", password, score)
+		}
+	}
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MinStrength: 2}
+
+	err := policy.Validate("")
+	policyErr := &PasswordPolicyError{}
+	if !errors.As(err, &policyErr) || policyErr.Problems[0].Code != "too_short" {
+		t.Fatalf("expected too_short, got %v", err)
+	}
+
+	err = policy.Validate(strings.Repeat("x7!Q", 20))
+	if !errors.As(err, &policyErr) || policyErr.Problems[0].Code != "too_long" {
+		t.Fatalf("expected too_long, got %v", err)
+	}
+
+	if err := policy.Validate("correct horse battery staple"); err != nil {
+		t.Fatalf("expected strong password to pass, got %v", err)
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha1.Sum([]byte("correct horse battery staple"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	os.WriteFile(filepath.Join(dir, hash[:5]), []byte("0000000000000000000000000000000000A:1\n"+hash[5:]+":42\n"), 0o644)
+
+	breached := &BreachedPasswords{Dir: dir}
+	found, err := breached.Contains("correct horse battery staple")
+	if err != nil || !found {
+		t.Fatalf("expected password to be found, got %v %v", found, err)
+	}
+	found, err = breached.Contains("a different passphrase entirely")
+	if err != nil || found {
+		t.Fatalf("expected password not to be found, got %v %v", found, err)
+	}
+
+	policy := PasswordPolicy{MinLength: 8, Breached: breached}
+	policyErr := &PasswordPolicyError{}
+	if err := policy.Validate("correct horse battery staple"); !errors.As(err, &policyErr) || policyErr.Problems[0].Code != "breached" {
+		t.Fatalf("expected breached, got %v", err)
+	}
+}
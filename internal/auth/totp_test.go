@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcTOTPSecret is the RFC 6238 SHA1 test key "12345678901234567890" in base32.
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range cases {
+		actual, err := TOTPCode(rfcTOTPSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if actual != expected {
+			t.Fatalf("at %d expected %s, got %s", unix, expected, actual)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	if !ValidateTOTP(rfcTOTPSecret, "081804", now.Add(30*time.Second)) {
+		t.Fatalf("code from the previous period should be accepted")
+	}
+	if ValidateTOTP(rfcTOTPSecret, "081804", now.Add(2*time.Minute)) {
+		t.Fatalf("stale code should be rejected")
+	}
+}
+
+func TestMatchTOTPReturnsStep(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step, ok := MatchTOTP(rfcTOTPSecret, "081804", now.Add(30*time.Second))
+	if !ok || step != 1111111109/30 {
+		t.Fatalf("got step %d, %v, want %d", step, ok, 1111111109/30)
+	}
+	if _, ok := MatchTOTP(rfcTOTPSecret, "000000", now); ok {
+		t.Fatalf("wrong code should not match")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("ABC", "Chirpy", "a@example.com")
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:a@example.com?") || !strings.Contains(uri, "secret=ABC") {
+		t.Fatalf("unexpected uri: %s", uri)
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrWebhookSignatureMissing  = errors.New("webhook signature or timestamp is missing")
+	ErrWebhookTimestampExpired  = errors.New("webhook timestamp is outside the allowed window")
+	ErrWebhookSignatureMismatch = errors.New("webhook signature does not match")
+)
+
+// SignWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>". Binding
+// the timestamp into the signature stops old requests from being replayed
+// with a fresh timestamp.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a signed webhook. timestamp is unix seconds and must
+// be within tolerance of now; signature may carry a "sha256=" prefix. Any of
+// secrets is accepted so a new secret can be rolled out before the old one
+// is retired.
+func VerifyWebhook(secrets []string, timestamp, signature string, body []byte, now time.Time, tolerance time.Duration) error {
+	if timestamp == "" || signature == "" {
+		return ErrWebhookSignatureMissing
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrWebhookSignatureMissing
+	}
+	sent := time.Unix(unix, 0)
+	if sent.Before(now.Add(-tolerance)) || sent.After(now.Add(tolerance)) {
+		return ErrWebhookTimestampExpired
+	}
+
+	given, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return ErrWebhookSignatureMismatch
+	}
+	for _, secret := range secrets {
+		expected, _ := hex.DecodeString(SignWebhook(secret, timestamp, body))
+		if hmac.Equal(expected, given) {
+			return nil
+		}
+	}
+	return ErrWebhookSignatureMismatch
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded"}`)
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := "sha256=" + SignWebhook("old", timestamp, body)
+
+	cases := []struct {
+		name      string
+		secrets   []string
+		timestamp string
+		signature string
+		body      []byte
+		want      error
+	}{
+		{"valid", []string{"old"}, timestamp, signature, body, nil},
+		{"rotated secret", []string{"new", "old"}, timestamp, signature, body, nil},
+		{"retired secret", []string{"new"}, timestamp, signature, body, ErrWebhookSignatureMismatch},
+		{"tampered body", []string{"old"}, timestamp, signature, []byte(`{"event":"user.downgraded"}`), ErrWebhookSignatureMismatch},
+		{"new timestamp on old signature", []string{"old"}, strconv.FormatInt(now.Unix()+1, 10), signature, body, ErrWebhookSignatureMismatch},
+		{"expired", []string{"old"}, strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10), signature, body, ErrWebhookTimestampExpired},
+		{"missing signature", []string{"old"}, timestamp, "", body, ErrWebhookSignatureMissing},
+		{"not hex", []string{"old"}, timestamp, "sha256=zz", body, ErrWebhookSignatureMismatch},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifyWebhook(tc.secrets, tc.timestamp, tc.signature, tc.body, now, 5*time.Minute)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
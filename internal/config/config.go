@@ -0,0 +1,342 @@
+// Package config loads chirpy's settings from defaults, an optional JSON
+// config file, environment variables and command line flags, in increasing
+// order of precedence.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is every setting chirpy reads at startup. Each field is described
+// by its tags: env is the environment variable, which lowercased is also the
+// key in the config file and, with dashes, the flag name; default is used
+// when no source sets it; secret hides the value when the config is printed.
+type Config struct {
+	Port     int    `env:"PORT" default:"8080" usage:"port to listen on"`
+	Platform string `env:"PLATFORM" usage:"dev enables development-only endpoints and defaults"`
+	DBURL    string `env:"DB_URL" secret:"true" usage:"Postgres connection URL"`
+
+	JWTSecret            string        `env:"JWT_SECRET" secret:"true" usage:"key access tokens are signed with"`
+	AccessTokenTTL       time.Duration `env:"ACCESS_TOKEN_TTL" default:"1h" usage:"lifetime of access tokens"`
+	RefreshTokenTTL      time.Duration `env:"REFRESH_TOKEN_TTL" default:"1440h" usage:"lifetime of refresh tokens"`
+	OAuthAccessTokenTTL  time.Duration `env:"OAUTH_ACCESS_TOKEN_TTL" default:"1h" usage:"lifetime of access tokens issued to OAuth clients"`
+	OAuthRefreshTokenTTL time.Duration `env:"OAUTH_REFRESH_TOKEN_TTL" default:"1440h" usage:"lifetime of refresh tokens issued to OAuth clients"`
+
+	PolkaKey            string   `env:"POLKA_KEY" secret:"true" usage:"static API key Polka webhooks send"`
+	PolkaWebhookSecrets []string `env:"POLKA_WEBHOOK_SECRETS" secret:"true" usage:"comma separated secrets Polka webhooks are signed with"`
+
+	Mailer       string `env:"MAILER" usage:"mail transport: smtp, file or memory (default file on dev)"`
+	MailFrom     string `env:"MAIL_FROM" default:"chirpy@localhost" usage:"sender address of outgoing mail"`
+	MailDir      string `env:"MAIL_DIR" default:"mail" usage:"directory the file mailer writes to"`
+	SMTPHost     string `env:"SMTP_HOST" usage:"SMTP server host"`
+	SMTPPort     string `env:"SMTP_PORT" default:"587" usage:"SMTP server port"`
+	SMTPUsername string `env:"SMTP_USERNAME" usage:"SMTP username"`
+	SMTPPassword string `env:"SMTP_PASSWORD" secret:"true" usage:"SMTP password"`
+
+	WebauthnRPID      string   `env:"WEBAUTHN_RP_ID" default:"localhost" usage:"WebAuthn relying party id"`
+	WebauthnRPOrigins []string `env:"WEBAUTHN_RP_ORIGINS" usage:"comma separated WebAuthn origins (default http://localhost:<port>)"`
+	OIDCProviders     []string `env:"OIDC_PROVIDERS" usage:"comma separated identity providers to enable"`
+
+	PasswordMinLength    int    `env:"PASSWORD_MIN_LENGTH" default:"8" usage:"shortest password accepted"`
+	PasswordMinStrength  int    `env:"PASSWORD_MIN_STRENGTH" default:"2" usage:"lowest password strength score accepted, 0 to 4"`
+	BreachedPasswordsDir string `env:"BREACHED_PASSWORDS_DIR" usage:"directory of breached password hash ranges"`
+	EntitlementsFile     string `env:"ENTITLEMENTS_FILE" usage:"JSON file with plan limits"`
+
+	ReadTimeout        time.Duration `env:"READ_TIMEOUT" default:"15s" usage:"longest time to read a request"`
+	WriteTimeout       time.Duration `env:"WRITE_TIMEOUT" default:"30s" usage:"longest time to write a response"`
+	IdleTimeout        time.Duration `env:"IDLE_TIMEOUT" default:"2m" usage:"how long idle keep-alive connections stay open"`
+	ShutdownDrainDelay time.Duration `env:"SHUTDOWN_DRAIN_DELAY" default:"5s" usage:"how long readiness fails before shutdown starts"`
+	ShutdownTimeout    time.Duration `env:"SHUTDOWN_TIMEOUT" default:"30s" usage:"how long in-flight requests get to finish on shutdown"`
+
+	MetricsToken       string `env:"METRICS_TOKEN" secret:"true" usage:"bearer token required to scrape /metrics, optional on dev"`
+	LogFormat          string `env:"LOG_FORMAT" usage:"json or text (default text on dev, json elsewhere)"`
+	LogLevel           string `env:"LOG_LEVEL" default:"info" usage:"debug, info, warn or error"`
+	OTELTracesExporter string `env:"OTEL_TRACES_EXPORTER" default:"none" usage:"otlp, console or none"`
+
+	// OIDC holds each provider named in OIDC_PROVIDERS. Its settings are
+	// OIDC_<NAME>_ISSUER and so on, read from the config file and the
+	// environment; there are no flags for them since the names are only
+	// known once the flags are parsed.
+	OIDC map[string]*OIDCProvider
+}
+
+// OIDCProvider configures one identity provider. Its env tags are suffixes
+// of OIDC_<NAME>_.
+type OIDCProvider struct {
+	Issuer       string `env:"ISSUER" usage:"OpenID Connect issuer URL"`
+	ClientID     string `env:"CLIENT_ID" usage:"OAuth client id"`
+	ClientSecret string `env:"CLIENT_SECRET" secret:"true" usage:"OAuth client secret"`
+	RedirectURL  string `env:"REDIRECT_URL" usage:"callback URL registered with the provider"`
+}
+
+// Load builds a Config from args, the command line without the program
+// name, and getenv. The config file is named by the -config flag or the
+// CHIRPY_CONFIG variable. It returns the arguments left after the flags,
+// which name a command. Every value that fails to parse is reported in one
+// joined error; call Validate to check the values make sense together.
+func Load(args []string, getenv func(string) string) (*Config, []string, error) {
+	cfg := &Config{OIDC: map[string]*OIDCProvider{}}
+	fields := fieldsOf(cfg, "")
+
+	fs := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+	configPath := fs.String("config", getenv("CHIRPY_CONFIG"), "JSON config file")
+	flagValues := map[string]*string{}
+	for _, f := range fields {
+		flagValues[f.env] = fs.String(f.flagName(), f.defaultValue, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	setFlags := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { setFlags[fl.Name] = true })
+
+	var errs []error
+	set := func(f field, value, source string) {
+		if err := f.set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s from %s: %w", f.env, source, err))
+		}
+	}
+
+	for _, f := range fields {
+		if f.defaultValue != "" {
+			set(f, f.defaultValue, "default")
+		}
+	}
+	file := map[string]string{}
+	if *configPath != "" {
+		var err error
+		if file, err = readFile(*configPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	// fromFileAndEnv applies the file and then the environment to fields,
+	// taking what it used out of file
+	fromFileAndEnv := func(fields []field) {
+		for _, f := range fields {
+			if value, ok := file[strings.ToLower(f.env)]; ok {
+				set(f, value, *configPath)
+				delete(file, strings.ToLower(f.env))
+			}
+		}
+		for _, f := range fields {
+			if value := getenv(f.env); value != "" {
+				set(f, value, "environment")
+			}
+		}
+	}
+	fromFileAndEnv(fields)
+	for _, f := range fields {
+		if setFlags[f.flagName()] {
+			set(f, *flagValues[f.env], "flag -"+f.flagName())
+		}
+	}
+
+	for _, name := range cfg.OIDCProviders {
+		provider := &OIDCProvider{}
+		cfg.OIDC[name] = provider
+		fromFileAndEnv(fieldsOf(provider, oidcPrefix(name)))
+	}
+	for _, key := range slices.Sorted(maps.Keys(file)) {
+		errs = append(errs, fmt.Errorf("%s: unknown setting %q", *configPath, key))
+	}
+
+	if len(cfg.WebauthnRPOrigins) == 0 {
+		cfg.WebauthnRPOrigins = []string{"http://localhost:" + strconv.Itoa(cfg.Port)}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// Validate checks everything the server needs, returning every problem at
+// once rather than stopping at the first.
+func (c *Config) Validate() error {
+	var errs []error
+	require := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	require(c.Platform != "", "PLATFORM must be set")
+	require(c.DBURL != "", "DB_URL must be set")
+	require(c.JWTSecret != "", "JWT_SECRET must be set")
+	require(c.PolkaKey != "" || len(c.PolkaWebhookSecrets) > 0, "POLKA_KEY or POLKA_WEBHOOK_SECRETS must be set")
+	require(c.Port > 0 && c.Port < 65536, "PORT must be between 1 and 65535")
+	require(c.AccessTokenTTL > 0, "ACCESS_TOKEN_TTL must be positive")
+	require(c.RefreshTokenTTL > c.AccessTokenTTL, "REFRESH_TOKEN_TTL must be longer than ACCESS_TOKEN_TTL")
+	require(c.OAuthAccessTokenTTL > 0, "OAUTH_ACCESS_TOKEN_TTL must be positive")
+	require(c.OAuthRefreshTokenTTL > c.OAuthAccessTokenTTL, "OAUTH_REFRESH_TOKEN_TTL must be longer than OAUTH_ACCESS_TOKEN_TTL")
+	require(c.PasswordMinLength > 0, "PASSWORD_MIN_LENGTH must be positive")
+	require(c.PasswordMinStrength >= 0 && c.PasswordMinStrength <= 4, "PASSWORD_MIN_STRENGTH must be between 0 and 4")
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"READ_TIMEOUT", c.ReadTimeout},
+		{"WRITE_TIMEOUT", c.WriteTimeout},
+		{"IDLE_TIMEOUT", c.IdleTimeout},
+		{"SHUTDOWN_DRAIN_DELAY", c.ShutdownDrainDelay},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+	} {
+		require(d.value >= 0, "%s must not be negative", d.name)
+	}
+
+	switch c.Mailer {
+	case "smtp":
+		require(c.SMTPHost != "", "SMTP_HOST must be set when MAILER is smtp")
+	case "file", "memory":
+	case "":
+		require(c.Platform == "dev", "MAILER must be set outside of dev")
+	default:
+		errs = append(errs, errors.New("MAILER must be one of smtp, file or memory"))
+	}
+	for _, name := range c.OIDCProviders {
+		provider, prefix := c.OIDC[name], oidcPrefix(name)
+		require(provider != nil && provider.Issuer != "" && provider.ClientID != "" && provider.RedirectURL != "",
+			"%sISSUER, %sCLIENT_ID and %sREDIRECT_URL must be set", prefix, prefix, prefix)
+	}
+	// /metrics exposes process, database and traffic details
+	require(c.MetricsToken != "" || c.Platform == "dev", "METRICS_TOKEN must be set outside of dev")
+	require(slices.Contains([]string{"", "json", "text"}, c.LogFormat), "LOG_FORMAT must be json or text")
+	require(slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(c.LogLevel)), "LOG_LEVEL must be debug, info, warn or error")
+	require(slices.Contains([]string{"", "none", "otlp", "console", "stdout"}, c.OTELTracesExporter), "OTEL_TRACES_EXPORTER must be otlp, console or none")
+
+	return errors.Join(errs...)
+}
+
+// Print writes the effective config one NAME=value line at a time, with
+// secrets that are set replaced by a placeholder.
+func (c *Config) Print(w io.Writer) {
+	fields := fieldsOf(c, "")
+	for _, name := range c.OIDCProviders {
+		if provider, ok := c.OIDC[name]; ok {
+			fields = append(fields, fieldsOf(provider, oidcPrefix(name))...)
+		}
+	}
+	for _, f := range fields {
+		value := f.String()
+		if f.secret && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(w, "%s=%s\n", f.env, value)
+	}
+}
+
+func oidcPrefix(name string) string {
+	return "OIDC_" + strings.ToUpper(name) + "_"
+}
+
+type field struct {
+	env          string
+	defaultValue string
+	usage        string
+	secret       bool
+	value        reflect.Value
+}
+
+// fieldsOf lists the tagged fields of the struct s points to, with prefix
+// put in front of their env names.
+func fieldsOf(s interface{}, prefix string) []field {
+	v := reflect.ValueOf(s).Elem()
+	fields := []field{}
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag
+		if tag.Get("env") == "" {
+			continue
+		}
+		fields = append(fields, field{
+			env:          prefix + tag.Get("env"),
+			defaultValue: tag.Get("default"),
+			usage:        tag.Get("usage"),
+			secret:       tag.Get("secret") == "true",
+			value:        v.Field(i),
+		})
+	}
+	return fields
+}
+
+func (f field) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(f.env), "_", "-")
+}
+
+func (f field) set(value string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(value)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		f.value.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 90s or 1h", value)
+		}
+		f.value.SetInt(int64(d))
+	case []string:
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", f.value.Type())
+	}
+	return nil
+}
+
+func (f field) String() string {
+	switch v := f.value.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// readFile reads a JSON object whose values are strings, numbers, booleans
+// or lists of strings, and turns them into the same text the environment
+// would hold.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	values := map[string]string{}
+	for key, value := range raw {
+		switch v := value.(type) {
+		case []interface{}:
+			items := []string{}
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[strings.ToLower(key)] = strings.Join(items, ",")
+		case float64:
+			values[strings.ToLower(key)] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			values[strings.ToLower(key)] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
@@ -7,6 +7,7 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -139,6 +140,29 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 	return i, err
 }
 
+const getRecentChirpActivity = `-- name: GetRecentChirpActivity :one
+SELECT COUNT(*) AS chirp_count, COALESCE(MIN(created_at), NOW())::TIMESTAMP AS oldest
+FROM chirps
+WHERE user_id = $1 AND created_at > $2
+`
+
+type GetRecentChirpActivityParams struct {
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type GetRecentChirpActivityRow struct {
+	ChirpCount int64
+	Oldest     time.Time
+}
+
+func (q *Queries) GetRecentChirpActivity(ctx context.Context, arg GetRecentChirpActivityParams) (GetRecentChirpActivityRow, error) {
+	row := q.db.QueryRowContext(ctx, getRecentChirpActivity, arg.UserID, arg.CreatedAt)
+	var i GetRecentChirpActivityRow
+	err := row.Scan(&i.ChirpCount, &i.Oldest)
+	return i, err
+}
+
 const listChirpsASC = `-- name: ListChirpsASC :many
 SELECT id, created_at, updated_at, body, user_id FROM chirps
 ORDER BY created_at ASC
@@ -206,3 +230,28 @@ func (q *Queries) ListChirpsDESC(ctx context.Context) ([]Chirp, error) {
 	}
 	return items, nil
 }
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id
+`
+
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+	)
+	return i, err
+}
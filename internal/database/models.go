@@ -6,6 +6,7 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,6 +20,86 @@ type Chirp struct {
 	UserID    uuid.UUID
 }
 
+type EmailChange struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	NewEmail  string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type EmailVerification struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type Identity struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Provider  string
+	Subject   string
+	Email     string
+}
+
+type LoginThrottle struct {
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+	LockedUntil   sql.NullTime
+}
+
+type MfaChallenge struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scope         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	UserID       uuid.UUID
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris string
+}
+
+type OauthState struct {
+	StateHash    string
+	CreatedAt    time.Time
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	ExpiresAt    time.Time
+}
+
+type PasswordReset struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
 type RefreshToken struct {
 	Token     string
 	CreatedAt time.Time
@@ -26,13 +107,131 @@ type RefreshToken struct {
 	UserID    uuid.UUID
 	ExpiresAt sql.NullTime
 	RevokedAt sql.NullTime
+	ClientID  sql.NullString
+	Scope     string
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
+}
+
+type Subscription struct {
+	UserID           uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Plan             string
+	Status           string
+	CurrentPeriodEnd time.Time
+}
+
+type SubscriptionHistory struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UserID           uuid.UUID
+	EventType        string
+	Plan             string
+	Status           string
+	CurrentPeriodEnd time.Time
+	WebhookEventID   uuid.NullUUID
+}
+
+type TotpUsedStep struct {
+	UserID uuid.UUID
+	Step   int64
+	UsedAt time.Time
 }
 
 type User struct {
+	ID                  uuid.UUID
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	Email               string
+	HashedPassword      string
+	IsChirpyRed         sql.NullBool
+	EmailVerifiedAt     sql.NullTime
+	TotpSecret          sql.NullString
+	TotpEnabledAt       sql.NullTime
+	DeletionRequestedAt sql.NullTime
+	Role                string
+}
+
+type UserProfile struct {
+	UserID      uuid.UUID
+	UpdatedAt   time.Time
+	Handle      sql.NullString
+	DisplayName string
+	Bio         string
+}
+
+type WebhookDelivery struct {
 	ID             uuid.UUID
 	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    sql.NullBool
+	EndpointID     uuid.UUID
+	EventType      string
+	Payload        string
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	LastAttemptAt  sql.NullTime
+	LastStatusCode sql.NullInt32
+	LastError      string
+}
+
+type WebhookDeliveryAttempt struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	DeliveryID uuid.UUID
+	StatusCode sql.NullInt32
+	Error      string
+	DurationMs int32
+}
+
+type WebhookEndpoint struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.NullUUID
+	Url        string
+	Secret     string
+	EventTypes string
+	Active     bool
+}
+
+type WebhookEvent struct {
+	ID          uuid.UUID
+	Source      string
+	EventID     string
+	EventType   string
+	Payload     string
+	ReceivedAt  time.Time
+	ProcessedAt sql.NullTime
+	Outcome     string
+	Error       string
+	Attempts    int32
+}
+
+type WebauthnCredential struct {
+	ID              []byte
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	UserID          uuid.UUID
+	PublicKey       []byte
+	AttestationType string
+	Aaguid          []byte
+	SignCount       int64
+	Transports      string
+	LastUsedAt      sql.NullTime
+}
+
+type WebauthnSession struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UserID      uuid.NullUUID
+	Ceremony    string
+	SessionData json.RawMessage
+	ExpiresAt   time.Time
 }
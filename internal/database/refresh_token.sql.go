@@ -8,10 +8,55 @@ package database
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const createClientRefreshToken = `-- name: CreateClientRefreshToken :one
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, client_id, scope)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, client_id, scope
+`
+
+type CreateClientRefreshTokenParams struct {
+	Token     string
+	UserID    uuid.UUID
+	ExpiresAt sql.NullTime
+	ClientID  sql.NullString
+	Scope     string
+}
+
+func (q *Queries) CreateClientRefreshToken(ctx context.Context, arg CreateClientRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, createClientRefreshToken,
+		arg.Token,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.ClientID,
+		arg.Scope,
+	)
+	var i RefreshToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.ClientID,
+		&i.Scope,
+	)
+	return i, err
+}
+
 const createRefreshToken = `-- name: CreateRefreshToken :one
 INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
 VALUES (
@@ -22,7 +67,7 @@ VALUES (
     $3,
     $4
 )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, client_id, scope
 `
 
 type CreateRefreshTokenParams struct {
@@ -47,12 +92,36 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.ClientID,
+		&i.Scope,
+	)
+	return i, err
+}
+
+const getRefreshToken = `-- name: GetRefreshToken :one
+SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, client_id, scope
+FROM refresh_tokens
+WHERE token = $1
+`
+
+func (q *Queries) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshToken, token)
+	var i RefreshToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
 
 const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
-SELECT user_id, revoked_at
+SELECT user_id, revoked_at, expires_at, client_id
 FROM refresh_tokens
 WHERE token = $1
 `
@@ -60,15 +129,91 @@ WHERE token = $1
 type GetUserFromRefreshTokenRow struct {
 	UserID    uuid.UUID
 	RevokedAt sql.NullTime
+	ExpiresAt sql.NullTime
+	ClientID  sql.NullString
 }
 
 func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (GetUserFromRefreshTokenRow, error) {
 	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, token)
 	var i GetUserFromRefreshTokenRow
-	err := row.Scan(&i.UserID, &i.RevokedAt)
+	err := row.Scan(
+		&i.UserID,
+		&i.RevokedAt,
+		&i.ExpiresAt,
+		&i.ClientID,
+	)
 	return i, err
 }
 
+const listRefreshTokensForUser = `-- name: ListRefreshTokensForUser :many
+SELECT created_at, expires_at, revoked_at, client_id, scope
+FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at ASC
+`
+
+type ListRefreshTokensForUserRow struct {
+	CreatedAt time.Time
+	ExpiresAt sql.NullTime
+	RevokedAt sql.NullTime
+	ClientID  sql.NullString
+	Scope     string
+}
+
+func (q *Queries) ListRefreshTokensForUser(ctx context.Context, userID uuid.UUID) ([]ListRefreshTokensForUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, listRefreshTokensForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRefreshTokensForUserRow
+	for rows.Next() {
+		var i ListRefreshTokensForUserRow
+		if err := rows.Scan(
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.ClientID,
+			&i.Scope,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeActiveRefreshToken = `-- name: RevokeActiveRefreshToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE token = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeActiveRefreshToken(ctx context.Context, token string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeActiveRefreshToken, token)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeAllRefreshTokensForUser = `-- name: RevokeAllRefreshTokensForUser :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllRefreshTokensForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllRefreshTokensForUser, userID)
+	return err
+}
+
 const revokeRefreshToken = `-- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
@@ -12,6 +12,33 @@ import (
 	"github.com/google/uuid"
 )
 
+const cancelUserDeletion = `-- name: CancelUserDeletion :exec
+UPDATE users
+SET deletion_requested_at = NULL, updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, cancelUserDeletion, id)
+	return err
+}
+
+const changeEmail = `-- name: ChangeEmail :exec
+UPDATE users
+SET email = $2, email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1
+`
+
+type ChangeEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) ChangeEmail(ctx context.Context, arg ChangeEmailParams) error {
+	_, err := q.db.ExecContext(ctx, changeEmail, arg.ID, arg.Email)
+	return err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red)
 VALUES (
@@ -22,7 +49,7 @@ VALUES (
     $2,
     $3
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, totp_secret, totp_enabled_at, deletion_requested_at, role
 `
 
 type CreateUserParams struct {
@@ -41,6 +68,11 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.DeletionRequestedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -54,8 +86,21 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 	return err
 }
 
+const deleteUsersPendingDeletion = `-- name: DeleteUsersPendingDeletion :execrows
+DELETE FROM users
+WHERE deletion_requested_at < $1
+`
+
+func (q *Queries) DeleteUsersPendingDeletion(ctx context.Context, deletionRequestedAt sql.NullTime) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUsersPendingDeletion, deletionRequestedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, totp_secret, totp_enabled_at, deletion_requested_at, role
 FROM users
 WHERE email = $1
 `
@@ -70,23 +115,124 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.DeletionRequestedAt,
+		&i.Role,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, totp_secret, totp_enabled_at, deletion_requested_at, role
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.DeletionRequestedAt,
+		&i.Role,
 	)
 	return i, err
 }
 
-const updateEmailandPassword = `-- name: UpdateEmailandPassword :exec
+const getUserRole = `-- name: GetUserRole :one
+SELECT role
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserRole(ctx context.Context, id uuid.UUID) (string, error) {
+	row := q.db.QueryRowContext(ctx, getUserRole, id)
+	var role string
+	err := row.Scan(&role)
+	return role, err
+}
+
+const lockUser = `-- name: LockUser :exec
+SELECT id
+FROM users
+WHERE id = $1
+FOR NO KEY UPDATE
+`
+
+// serializes writes that check a per-user quota first, such as creating
+// chirps under the hourly limit; NO KEY UPDATE leaves inserts that only
+// reference the user unblocked
+func (q *Queries) LockUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, lockUser, id)
+	return err
+}
+
+const markEmailVerified = `-- name: MarkEmailVerified :exec
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) MarkEmailVerified(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, markEmailVerified, id)
+	return err
+}
+
+const requestUserDeletion = `-- name: RequestUserDeletion :one
+UPDATE users
+SET deletion_requested_at = NOW(), updated_at = NOW()
+WHERE id = $1
+RETURNING deletion_requested_at
+`
+
+func (q *Queries) RequestUserDeletion(ctx context.Context, id uuid.UUID) (sql.NullTime, error) {
+	row := q.db.QueryRowContext(ctx, requestUserDeletion, id)
+	var deletion_requested_at sql.NullTime
+	err := row.Scan(&deletion_requested_at)
+	return deletion_requested_at, err
+}
+
+const setUserRole = `-- name: SetUserRole :execrows
 UPDATE users
-SET email = $2, hashed_password = $3, updated_at = NOW()
-where id = $1
+SET role = $2, updated_at = NOW()
+WHERE id = $1
 `
 
-type UpdateEmailandPasswordParams struct {
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserRole, arg.ID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updatePassword = `-- name: UpdatePassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdatePasswordParams struct {
 	ID             uuid.UUID
-	Email          string
 	HashedPassword string
 }
 
-func (q *Queries) UpdateEmailandPassword(ctx context.Context, arg UpdateEmailandPasswordParams) error {
-	_, err := q.db.ExecContext(ctx, updateEmailandPassword, arg.ID, arg.Email, arg.HashedPassword)
+func (q *Queries) UpdatePassword(ctx context.Context, arg UpdatePasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updatePassword, arg.ID, arg.HashedPassword)
 	return err
 }
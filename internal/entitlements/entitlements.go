@@ -0,0 +1,92 @@
+// Package entitlements declares what each Chirpy plan allows.
+package entitlements
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// FreePlan applies to users without a running subscription.
+const FreePlan = "free"
+
+// Limits are the features and quotas of a plan. A zero EditWindow means
+// chirps cannot be edited; a zero ChirpsPerHour means no rate limit.
+type Limits struct {
+	MaxChirpLength int      `json:"max_chirp_length"`
+	EditWindow     Duration `json:"edit_window"`
+	ChirpsPerHour  int      `json:"chirps_per_hour"`
+}
+
+// Plans maps plan names to their limits and must include FreePlan.
+type Plans map[string]Limits
+
+// Default is used when no entitlements file is configured.
+var Default = Plans{
+	FreePlan: {MaxChirpLength: 140, ChirpsPerHour: 30},
+	"red":    {MaxChirpLength: 500, EditWindow: Duration(15 * time.Minute), ChirpsPerHour: 300},
+}
+
+// For returns the limits of plan, falling back to the free plan for plans
+// that are not configured.
+func (p Plans) For(plan string) Limits {
+	if limits, ok := p[plan]; ok {
+		return limits
+	}
+	return p[FreePlan]
+}
+
+// Load reads plans from a JSON file such as
+//
+//	{"free": {"max_chirp_length": 140, "chirps_per_hour": 30},
+//	 "red": {"max_chirp_length": 500, "edit_window": "15m", "chirps_per_hour": 300}}
+func Load(path string) (Plans, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plans := Plans{}
+	if err := json.Unmarshal(data, &plans); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := plans.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plans, nil
+}
+
+func (p Plans) Validate() error {
+	if _, ok := p[FreePlan]; !ok {
+		return fmt.Errorf("plan %q is required", FreePlan)
+	}
+	for name, limits := range p {
+		if limits.MaxChirpLength <= 0 {
+			return fmt.Errorf("plan %q: max_chirp_length must be positive", name)
+		}
+		if limits.EditWindow < 0 || limits.ChirpsPerHour < 0 {
+			return fmt.Errorf("plan %q: limits cannot be negative", name)
+		}
+	}
+	return nil
+}
+
+// Duration is a time.Duration written as a string like "15m" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,45 @@
+package entitlements
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFor(t *testing.T) {
+	if got := Default.For("red"); got.MaxChirpLength != 500 {
+		t.Fatalf("expected red plan limits, got %+v", got)
+	}
+	if got := Default.For("platinum"); got != Default[FreePlan] {
+		t.Fatalf("expected unknown plans to fall back to free, got %+v", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plans.json")
+	config := `{"free": {"max_chirp_length": 100}, "red": {"max_chirp_length": 1000, "edit_window": "1h", "chirps_per_hour": 50}}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	plans, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	red := plans.For("red")
+	if red.MaxChirpLength != 1000 || time.Duration(red.EditWindow) != time.Hour || red.ChirpsPerHour != 50 {
+		t.Fatalf("unexpected red limits: %+v", red)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Plans{"red": {MaxChirpLength: 10}}).Validate(); err == nil {
+		t.Fatalf("expected plans without a free plan to be rejected")
+	}
+	if err := (Plans{FreePlan: {}}).Validate(); err == nil {
+		t.Fatalf("expected a zero chirp length to be rejected")
+	}
+	if err := Default.Validate(); err != nil {
+		t.Fatalf("default plans should be valid: %v", err)
+	}
+}
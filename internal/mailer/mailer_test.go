@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMemoryMailer(t *testing.T) {
+	m := &MemoryMailer{}
+	m.Send(context.Background(), Message{To: "a@example.com", Subject: "hi", Body: "hello"})
+	messages := m.Messages()
+	if len(messages) != 1 || messages[0].To != "a@example.com" {
+		t.Fatalf("expected one message to a@example.com, got %v", messages)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: dir, From: "chirpy@example.com"}
+	if err := m.Send(context.Background(), Message{To: "a@example.com", Subject: "hi", Body: "hello"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(entries))
+	}
+	data, _ := os.ReadFile(dir + "/" + entries[0].Name())
+	if !strings.Contains(string(data), "Subject: hi") || !strings.HasSuffix(string(data), "hello") {
+		t.Fatalf("unexpected message contents: %q", data)
+	}
+}
@@ -0,0 +1,112 @@
+package passkey
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+var ErrCloned = errors.New("authenticator sign count did not increase, credential may be cloned")
+
+func New(rpID, rpDisplayName string, origins []string) (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpDisplayName,
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+}
+
+// User adapts a chirpy user and their stored credentials to webauthn.User.
+// The user handle is the raw 16 bytes of the user ID.
+type User struct {
+	ID          uuid.UUID
+	Email       string
+	Credentials []webauthn.Credential
+}
+
+func NewUser(dbUser database.User, dbCredentials []database.WebauthnCredential) *User {
+	user := &User{
+		ID:    dbUser.ID,
+		Email: dbUser.Email,
+	}
+	for _, dbCredential := range dbCredentials {
+		user.Credentials = append(user.Credentials, CredentialFromDB(dbCredential))
+	}
+	return user
+}
+
+func (u *User) WebAuthnID() []byte {
+	return u.ID[:]
+}
+
+func (u *User) WebAuthnName() string {
+	return u.Email
+}
+
+func (u *User) WebAuthnDisplayName() string {
+	return u.Email
+}
+
+func (u *User) WebAuthnCredentials() []webauthn.Credential {
+	return u.Credentials
+}
+
+func (u *User) WebAuthnIcon() string {
+	return ""
+}
+
+func UserIDFromHandle(userHandle []byte) (uuid.UUID, error) {
+	return uuid.FromBytes(userHandle)
+}
+
+// CheckSignCount rejects assertions whose signature counter went backwards,
+// which is the only signal we get that a credential was copied.
+func CheckSignCount(credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return ErrCloned
+	}
+	return nil
+}
+
+func CredentialFromDB(c database.WebauthnCredential) webauthn.Credential {
+	var transports []protocol.AuthenticatorTransport
+	if c.Transports != "" {
+		for _, transport := range strings.Split(c.Transports, ",") {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+	}
+	return webauthn.Credential{
+		ID:              c.ID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    c.Aaguid,
+			SignCount: uint32(c.SignCount),
+		},
+	}
+}
+
+func CreateCredentialParams(userID uuid.UUID, c *webauthn.Credential) database.CreateWebauthnCredentialParams {
+	transports := make([]string, len(c.Transport))
+	for i, transport := range c.Transport {
+		transports[i] = string(transport)
+	}
+	return database.CreateWebauthnCredentialParams{
+		ID:              c.ID,
+		UserID:          userID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Aaguid:          c.Authenticator.AAGUID,
+		SignCount:       int64(c.Authenticator.SignCount),
+		Transports:      strings.Join(transports, ","),
+	}
+}
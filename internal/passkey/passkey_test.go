@@ -0,0 +1,230 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:8080"
+)
+
+// softAuthenticator is a minimal platform authenticator holding one ES256
+// key, enough to drive both ceremonies end to end.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+	return &softAuthenticator{key: key, credentialID: credentialID}
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) []byte {
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1,
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestationObject, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(0x45, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    a.clientData(t, "webauthn.create", options.Response.Challenge.String()),
+		"attestationObject": encode(attestationObject),
+	})
+}
+
+func (a *softAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) []byte {
+	a.signCount++
+	clientDataJSON := a.clientData(t, "webauthn.get", options.Response.Challenge.String())
+	clientData, _ := base64.RawURLEncoding.DecodeString(clientDataJSON)
+	clientDataHash := sha256.Sum256(clientData)
+
+	authData := a.authData(0x05, nil)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    clientDataJSON,
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony, challenge string) string {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encode(data)
+}
+
+func (a *softAuthenticator) response(t *testing.T, response map[string]string) []byte {
+	data, err := json.Marshal(map[string]interface{}{
+		"id":       encode(a.credentialID),
+		"rawId":    encode(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// storeCredential round-trips a credential through its database row the way
+// the handlers do between ceremonies.
+func storeCredential(user *User, credential *webauthn.Credential) database.WebauthnCredential {
+	params := CreateCredentialParams(user.ID, credential)
+	return database.WebauthnCredential{
+		ID:              params.ID,
+		UserID:          params.UserID,
+		PublicKey:       params.PublicKey,
+		AttestationType: params.AttestationType,
+		Aaguid:          params.Aaguid,
+		SignCount:       params.SignCount,
+		Transports:      params.Transports,
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	wa, err := New(testRPID, "Chirpy", []string{testOrigin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := newSoftAuthenticator(t)
+	user := NewUser(database.User{ID: uuid.New(), Email: "a@example.com"}, nil)
+
+	creation, session, err := wa.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := authenticator.create(t, creation)
+	credential, err := wa.FinishRegistration(user, *session, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+	row := storeCredential(user, credential)
+
+	assertion, session, err := wa.BeginDiscoverableLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = authenticator.get(t, assertion)
+	var loggedIn uuid.UUID
+	credential, err = wa.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := UserIDFromHandle(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		loggedIn = userID
+		return &User{ID: userID, Email: user.Email, Credentials: []webauthn.Credential{CredentialFromDB(row)}}, nil
+	}, *session, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if loggedIn != user.ID {
+		t.Fatalf("expected user %s, got %s", user.ID, loggedIn)
+	}
+	if err := CheckSignCount(credential); err != nil {
+		t.Fatalf("unexpected sign count error: %v", err)
+	}
+	if credential.Authenticator.SignCount != 1 {
+		t.Fatalf("expected sign count 1, got %d", credential.Authenticator.SignCount)
+	}
+}
+
+func TestLoginRejectsClonedAuthenticator(t *testing.T) {
+	wa, err := New(testRPID, "Chirpy", []string{testOrigin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := newSoftAuthenticator(t)
+	user := NewUser(database.User{ID: uuid.New(), Email: "a@example.com"}, nil)
+
+	creation, session, _ := wa.BeginRegistration(user)
+	body := authenticator.create(t, creation)
+	credential, err := wa.FinishRegistration(user, *session, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+	row := storeCredential(user, credential)
+	row.SignCount = 10
+	user.Credentials = []webauthn.Credential{CredentialFromDB(row)}
+
+	assertion, session, _ := wa.BeginLogin(user)
+	body = authenticator.get(t, assertion)
+	credential, err = wa.FinishLogin(user, *session, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if err := CheckSignCount(credential); !errors.Is(err, ErrCloned) {
+		t.Fatalf("expected ErrCloned, got %v", err)
+	}
+}
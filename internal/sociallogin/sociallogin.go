@@ -0,0 +1,89 @@
+package sociallogin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrNonceMismatch = errors.New("id token nonce does not match")
+
+// Provider is an external OpenID Connect identity provider users can sign
+// in with using the authorization-code flow with PKCE.
+type Provider struct {
+	Name     string
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// Identity is what chirpy learns about a user from a verified ID token.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// NewProvider loads the provider's discovery document from issuer.
+func NewProvider(ctx context.Context, name, issuer, clientID, clientSecret, redirectURL string) (*Provider, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", name, err)
+	}
+	return &Provider{
+		Name: name,
+		oauth2: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// NewVerifier returns a random PKCE code verifier; the caller keeps it next
+// to the state until the callback.
+func NewVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+func (p *Provider) AuthCodeURL(state, verifier, nonce string) string {
+	return p.oauth2.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce))
+}
+
+// Exchange redeems an authorization code and verifies the ID token that
+// comes back with it, including that it was issued for this login's nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Identity, error) {
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Identity{}, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, errors.New("token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	if idToken.Nonce != nonce {
+		return Identity{}, ErrNonceMismatch
+	}
+
+	claims := struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, err
+	}
+	return Identity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
@@ -0,0 +1,173 @@
+package sociallogin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// mockProvider is a tiny OpenID Connect provider: it serves discovery and
+// JWKS, and its token endpoint only redeems codes whose PKCE verifier
+// matches the challenge sent to /authorize.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+type authorization struct {
+	challenge string
+	nonce     string
+	subject   string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, codes: map[string]authorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		p.mu.Lock()
+		auth, ok := p.codes[req.Form.Get("code")]
+		delete(p.codes, req.Form.Get("code"))
+		p.mu.Unlock()
+		if !ok || oauth2.S256ChallengeFromVerifier(req.Form.Get("code_verifier")) != auth.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            p.server.URL,
+			"aud":            "chirpy",
+			"sub":            auth.subject,
+			"email":          "a@example.com",
+			"email_verified": true,
+			"nonce":          auth.nonce,
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Minute).Unix(),
+		})
+		idToken.Header["kid"] = "test"
+		signed, err := idToken.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     signed,
+		})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize plays the part of the user approving the login in a browser.
+func (p *mockProvider) authorize(t *testing.T, authURL, subject string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected S256 PKCE challenge, got %q", query.Get("code_challenge_method"))
+	}
+	code := "code-" + subject
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		challenge: query.Get("code_challenge"),
+		nonce:     query.Get("nonce"),
+		subject:   subject,
+	}
+	p.mu.Unlock()
+	return code
+}
+
+func TestExchange(t *testing.T) {
+	mock := newMockProvider(t)
+	provider, err := NewProvider(context.Background(), "mock", mock.server.URL, "chirpy", "secret", "http://localhost:8080/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := NewVerifier()
+	code := mock.authorize(t, provider.AuthCodeURL("state", verifier, "nonce"), "user-1")
+	identity, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+	if err != nil {
+		t.Fatalf("exchange failed: %v", err)
+	}
+	if identity.Subject != "user-1" || identity.Email != "a@example.com" || !identity.EmailVerified {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	mock := newMockProvider(t)
+	provider, err := NewProvider(context.Background(), "mock", mock.server.URL, "chirpy", "secret", "http://localhost:8080/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := mock.authorize(t, provider.AuthCodeURL("state", NewVerifier(), "nonce"), "user-1")
+	if _, err := provider.Exchange(context.Background(), code, NewVerifier(), "nonce"); err == nil {
+		t.Fatalf("expected exchange with the wrong verifier to fail")
+	}
+}
+
+func TestExchangeRejectsWrongNonce(t *testing.T) {
+	mock := newMockProvider(t)
+	provider, err := NewProvider(context.Background(), "mock", mock.server.URL, "chirpy", "secret", "http://localhost:8080/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := NewVerifier()
+	code := mock.authorize(t, provider.AuthCodeURL("state", verifier, "nonce"), "user-1")
+	if _, err := provider.Exchange(context.Background(), code, verifier, "other"); !errors.Is(err, ErrNonceMismatch) {
+		t.Fatalf("expected ErrNonceMismatch, got %v", err)
+	}
+}
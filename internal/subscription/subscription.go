@@ -0,0 +1,128 @@
+// Package subscription models the Chirpy Red subscription lifecycle driven
+// by Polka billing events.
+package subscription
+
+import (
+	"fmt"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusActive   Status = "active"
+	StatusPastDue  Status = "past_due"
+	StatusCanceled Status = "canceled"
+	StatusExpired  Status = "expired"
+)
+
+// Event types Polka sends.
+const (
+	EventUpgraded      = "user.upgraded"
+	EventRenewed       = "subscription.renewed"
+	EventPaymentFailed = "subscription.payment_failed"
+	EventCanceled      = "subscription.canceled"
+	EventDowngraded    = "user.downgraded"
+)
+
+const (
+	DefaultPlan = "red"
+	// Period is assumed when an event does not say when the period ends.
+	Period = 30 * 24 * time.Hour
+	// GracePeriod keeps an active or past-due subscription entitled after
+	// its period ends, giving a late renewal or payment retry time to land.
+	GracePeriod = 3 * 24 * time.Hour
+)
+
+type Subscription struct {
+	Plan             string
+	Status           Status
+	CurrentPeriodEnd time.Time
+}
+
+// Event is a billing event. Plan and PeriodEnd are optional.
+type Event struct {
+	Type      string
+	Plan      string
+	PeriodEnd time.Time
+}
+
+// Known reports whether eventType changes subscription state.
+func Known(eventType string) bool {
+	switch eventType {
+	case EventUpgraded, EventRenewed, EventPaymentFailed, EventCanceled, EventDowngraded:
+		return true
+	}
+	return false
+}
+
+// Entitled reports whether the subscription grants Chirpy Red at now.
+// Canceled subscriptions run to the end of the paid period; active and
+// past-due ones get the grace period on top.
+func (s Subscription) Entitled(now time.Time) bool {
+	switch s.Status {
+	case StatusActive, StatusPastDue:
+		return now.Before(s.CurrentPeriodEnd.Add(GracePeriod))
+	case StatusCanceled:
+		return now.Before(s.CurrentPeriodEnd)
+	}
+	return false
+}
+
+// Apply returns the subscription after event. The zero Subscription is a
+// user who never subscribed.
+func (s Subscription) Apply(event Event, now time.Time) (Subscription, error) {
+	next := s
+	if event.Plan != "" {
+		next.Plan = event.Plan
+	}
+	if next.Plan == "" {
+		next.Plan = DefaultPlan
+	}
+
+	switch event.Type {
+	case EventUpgraded:
+		next.Status = StatusActive
+		next.CurrentPeriodEnd = periodEnd(event, now)
+	case EventRenewed:
+		start := now
+		if s.CurrentPeriodEnd.After(now) {
+			start = s.CurrentPeriodEnd
+		}
+		next.Status = StatusActive
+		next.CurrentPeriodEnd = periodEnd(event, start)
+	case EventPaymentFailed:
+		if s.Status == "" || s.Status == StatusExpired {
+			return s, fmt.Errorf("payment failed for a subscription that is not running")
+		}
+		next.Status = StatusPastDue
+	case EventCanceled:
+		if s.Status == "" || s.Status == StatusExpired {
+			return s, fmt.Errorf("cannot cancel a subscription that is not running")
+		}
+		next.Status = StatusCanceled
+	case EventDowngraded:
+		next.Status = StatusExpired
+		next.CurrentPeriodEnd = now
+	default:
+		return s, fmt.Errorf("unknown subscription event %q", event.Type)
+	}
+	return next, nil
+}
+
+// Expire moves a subscription that no longer grants access to expired. It
+// reports whether anything changed.
+func (s Subscription) Expire(now time.Time) (Subscription, bool) {
+	if s.Status == StatusExpired || s.Entitled(now) {
+		return s, false
+	}
+	s.Status = StatusExpired
+	return s, true
+}
+
+func periodEnd(event Event, start time.Time) time.Time {
+	if !event.PeriodEnd.IsZero() {
+		return event.PeriodEnd
+	}
+	return start.Add(Period)
+}
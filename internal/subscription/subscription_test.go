@@ -0,0 +1,96 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestUpgradeAndRenew(t *testing.T) {
+	sub, err := Subscription{}.Apply(Event{Type: EventUpgraded}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Status != StatusActive || sub.Plan != DefaultPlan || !sub.CurrentPeriodEnd.Equal(now.Add(Period)) {
+		t.Fatalf("unexpected subscription after upgrade: %+v", sub)
+	}
+
+	// renewing early extends from the end of the paid period, not from now
+	renewed, err := sub.Apply(Event{Type: EventRenewed}, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !renewed.CurrentPeriodEnd.Equal(now.Add(2 * Period)) {
+		t.Fatalf("expected renewal to extend the period, got %v", renewed.CurrentPeriodEnd)
+	}
+
+	explicit, _ := sub.Apply(Event{Type: EventRenewed, PeriodEnd: now.Add(time.Hour)}, now)
+	if !explicit.CurrentPeriodEnd.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected the event's period end to win, got %v", explicit.CurrentPeriodEnd)
+	}
+}
+
+func TestEntitled(t *testing.T) {
+	end := now.Add(Period)
+	cases := []struct {
+		name string
+		sub  Subscription
+		at   time.Time
+		want bool
+	}{
+		{"never subscribed", Subscription{}, now, false},
+		{"active", Subscription{Status: StatusActive, CurrentPeriodEnd: end}, now, true},
+		{"active in grace period", Subscription{Status: StatusActive, CurrentPeriodEnd: end}, end.Add(GracePeriod - time.Second), true},
+		{"active after grace period", Subscription{Status: StatusActive, CurrentPeriodEnd: end}, end.Add(GracePeriod), false},
+		{"past due in grace period", Subscription{Status: StatusPastDue, CurrentPeriodEnd: end}, end.Add(time.Hour), true},
+		{"canceled before period end", Subscription{Status: StatusCanceled, CurrentPeriodEnd: end}, end.Add(-time.Second), true},
+		{"canceled after period end", Subscription{Status: StatusCanceled, CurrentPeriodEnd: end}, end.Add(time.Hour), false},
+		{"expired", Subscription{Status: StatusExpired, CurrentPeriodEnd: end}, now, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.sub.Entitled(tc.at); got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCancelPaymentFailedAndDowngrade(t *testing.T) {
+	sub, _ := Subscription{}.Apply(Event{Type: EventUpgraded}, now)
+
+	pastDue, err := sub.Apply(Event{Type: EventPaymentFailed}, now)
+	if err != nil || pastDue.Status != StatusPastDue {
+		t.Fatalf("expected past due, got %+v (%v)", pastDue, err)
+	}
+	canceled, err := pastDue.Apply(Event{Type: EventCanceled}, now)
+	if err != nil || canceled.Status != StatusCanceled || !canceled.Entitled(now) {
+		t.Fatalf("expected canceled but still entitled, got %+v (%v)", canceled, err)
+	}
+	downgraded, err := canceled.Apply(Event{Type: EventDowngraded}, now)
+	if err != nil || downgraded.Status != StatusExpired || downgraded.Entitled(now) {
+		t.Fatalf("expected downgrade to end access, got %+v (%v)", downgraded, err)
+	}
+
+	if _, err := (Subscription{}).Apply(Event{Type: EventCanceled}, now); err == nil {
+		t.Fatalf("expected canceling a missing subscription to fail")
+	}
+	if _, err := sub.Apply(Event{Type: "user.unknown"}, now); err == nil {
+		t.Fatalf("expected unknown events to fail")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	sub, _ := Subscription{}.Apply(Event{Type: EventUpgraded}, now)
+	if _, changed := sub.Expire(now); changed {
+		t.Fatalf("active subscription should not expire")
+	}
+	expired, changed := sub.Expire(now.Add(Period + GracePeriod))
+	if !changed || expired.Status != StatusExpired {
+		t.Fatalf("expected subscription to expire after the grace period, got %+v", expired)
+	}
+	if _, changed := expired.Expire(now.Add(2 * Period)); changed {
+		t.Fatalf("expired subscription should not change again")
+	}
+}
@@ -0,0 +1,178 @@
+// Package tracing sets up OpenTelemetry and instruments chirpy's HTTP
+// server and database queries with spans.
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/chichigami/chirpy/internal/tracing"
+
+// Setup installs the global tracer provider and W3C trace context
+// propagation. The exporter is picked the same way the OpenTelemetry SDKs
+// do: "otlp" sends spans to OTEL_EXPORTER_OTLP_ENDPOINT over HTTP,
+// "console" or "stdout" prints them for local use, and "none" or "" turns
+// tracing off. The returned function flushes pending spans.
+func Setup(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		spanExporter, err = otlptracehttp.New(ctx)
+	case "console", "stdout":
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, must be otlp, console or none", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("chirpy")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Handler starts a span for every request, continuing any trace the caller
+// sent in a traceparent header. The span is called "http.request" until
+// Route names it.
+func Handler(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.request")
+}
+
+// Route renames the request's span after the pattern the ServeMux matched,
+// which keeps ids in paths out of span names. It has to wrap the mux
+// itself: the mux sets Pattern on the request it is given, and a
+// middleware that calls req.WithContext hands the mux a copy its callers
+// never see.
+func Route(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mux.ServeHTTP(w, req)
+		if req.Pattern == "" {
+			return
+		}
+		span := trace.SpanFromContext(req.Context())
+		span.SetName(req.Pattern)
+		route := req.Pattern
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		}
+		span.SetAttributes(semconv.HTTPRoute(route))
+	})
+}
+
+// DBTX is the subset of *sql.DB the sqlc queries use.
+type DBTX interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}
+
+// DB wraps a DBTX so every query gets a span named after its sqlc query.
+type DB struct {
+	db     DBTX
+	tracer trace.Tracer
+}
+
+func WrapDB(db DBTX) *DB {
+	return &DB{db: db, tracer: otel.Tracer(instrumentationName)}
+}
+
+func (d *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := d.start(ctx, query)
+	defer span.End()
+	result, err := d.db.ExecContext(ctx, query, args...)
+	recordError(span, err)
+	return result, err
+}
+
+func (d *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, span := d.start(ctx, query)
+	defer span.End()
+	stmt, err := d.db.PrepareContext(ctx, query)
+	recordError(span, err)
+	return stmt, err
+}
+
+// QueryContext's span covers running the query, not reading the rows.
+func (d *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := d.start(ctx, query)
+	defer span.End()
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	recordError(span, err)
+	return rows, err
+}
+
+func (d *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := d.start(ctx, query)
+	defer span.End()
+	row := d.db.QueryRowContext(ctx, query, args...)
+	recordError(span, row.Err())
+	return row
+}
+
+func (d *DB) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	name := QueryName(query)
+	return d.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(name),
+			semconv.DBQueryText(query),
+		),
+	)
+}
+
+// QueryName reads the query name from the "-- name: GetChirp :one" comment
+// sqlc puts at the top of every query. Other SQL is just called "query".
+func QueryName(query string) string {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(query), "-- name: ")
+	if !ok {
+		return "query"
+	}
+	name, _, _ := strings.Cut(rest, " ")
+	return name
+}
+
+func recordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type fakeDB struct {
+	DBTX
+	err error
+}
+
+func (f fakeDB) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return nil, f.err
+}
+
+func newRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestQueryName(t *testing.T) {
+	tests := map[string]string{
+		"-- name: GetChirp :one\nSELECT * FROM chirps": "GetChirp",
+		"\n-- name: DeleteChirp :exec\nDELETE":         "DeleteChirp",
+		"SELECT 1":                                     "query",
+	}
+	for query, want := range tests {
+		if got := QueryName(query); got != want {
+			t.Errorf("QueryName(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestDBRecordsSpans(t *testing.T) {
+	recorder := newRecorder(t)
+
+	db := WrapDB(fakeDB{})
+	if _, err := db.ExecContext(context.Background(), "-- name: DeleteChirp :exec\nDELETE FROM chirps"); err != nil {
+		t.Fatal(err)
+	}
+	failing := WrapDB(fakeDB{err: errors.New("connection refused")})
+	failing.ExecContext(context.Background(), "-- name: CreateChirp :one\nINSERT")
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].Name() != "DeleteChirp" || spans[0].Status().Code == codes.Error {
+		t.Errorf("first span = %s %v", spans[0].Name(), spans[0].Status())
+	}
+	if spans[1].Name() != "CreateChirp" || spans[1].Status().Code != codes.Error {
+		t.Errorf("second span = %s %v", spans[1].Name(), spans[1].Status())
+	}
+}
+
+type testContextKey struct{}
+
+func TestRouteNamesSpanThroughMiddleware(t *testing.T) {
+	recorder := newRecorder(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	// a middleware that copies the request, hiding Pattern from Handler
+	copying := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), testContextKey{}, true)))
+		})
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/123", nil)
+	Handler(copying(Route(mux))).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if spans[0].Name() != "GET /api/chirps/{chirpID}" {
+		t.Errorf("span name = %q", spans[0].Name())
+	}
+	hasRoute := false
+	for _, attr := range spans[0].Attributes() {
+		if attr.Key == "http.route" && attr.Value.AsString() == "/api/chirps/{chirpID}" {
+			hasRoute = true
+		}
+	}
+	if !hasRoute {
+		t.Errorf("span attributes %v have no http.route", spans[0].Attributes())
+	}
+}
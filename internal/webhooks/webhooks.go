@@ -0,0 +1,157 @@
+// Package webhooks delivers signed event payloads to endpoints that users
+// register with chirpy.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/auth"
+)
+
+// Event types endpoints can subscribe to.
+const (
+	EventChirpCreated = "chirp.created"
+	EventChirpDeleted = "chirp.deleted"
+	EventUserUpgraded = "user.upgraded"
+)
+
+var EventTypes = []string{EventChirpCreated, EventChirpDeleted, EventUserUpgraded}
+
+const (
+	// MaxAttempts is how often a delivery is tried before it is given up.
+	MaxAttempts = 8
+	// Timeout bounds one delivery, connecting included.
+	Timeout     = 10 * time.Second
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+)
+
+var ErrPrivateAddress = errors.New("webhook endpoint resolves to a private address")
+
+// Client posts deliveries. Unless AllowPrivate is set it refuses to connect
+// to loopback, private and link-local addresses so endpoints can't be used
+// to reach chirpy's own network.
+type Client struct {
+	HTTP         *http.Client
+	AllowPrivate bool
+}
+
+func NewClient(allowPrivate bool) *Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &Client{
+		AllowPrivate: allowPrivate,
+		HTTP: &http.Client{
+			Timeout: Timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 5 * time.Second,
+			},
+			// a redirect could point anywhere, so don't follow it
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Delivery is one event on its way to one endpoint.
+type Delivery struct {
+	ID        string
+	EventType string
+	URL       string
+	Secret    string
+	Payload   []byte
+}
+
+// Deliver posts the payload signed with the endpoint's secret, in the same
+// format chirpy verifies incoming Polka webhooks. Any non-2xx status is an
+// error; the status code is returned whenever a response arrived.
+func (c *Client) Deliver(ctx context.Context, d Delivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set("X-Chirpy-Event", d.EventType)
+	req.Header.Set("X-Chirpy-Delivery", d.ID)
+	req.Header.Set("X-Chirpy-Timestamp", timestamp)
+	req.Header.Set("X-Chirpy-Signature", "sha256="+auth.SignWebhook(d.Secret, timestamp, d.Payload))
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Backoff is the wait before retrying after the given number of failed
+// attempts: 30s doubling up to 6h.
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	wait := baseBackoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return wait
+}
+
+// ValidateURL checks an endpoint URL when it is registered. Plain http is
+// only accepted when allowInsecure is set, for local development.
+func ValidateURL(rawURL string, allowInsecure bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return errors.New("url must be absolute")
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !allowInsecure {
+			return errors.New("url must use https")
+		}
+	default:
+		return errors.New("url must use https")
+	}
+	if u.User != nil {
+		return errors.New("url must not contain credentials")
+	}
+	return nil
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+}
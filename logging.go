@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const requestInfoContextKey contextKey = "requestInfo"
+
+// requestIDPattern limits which incoming X-Request-ID values are kept, so a
+// caller can't inject arbitrary text into the logs.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestInfo is what the request log knows about a request. Auth fills in
+// UserID once it has validated the token.
+type requestInfo struct {
+	ID     string
+	UserID uuid.UUID
+}
+
+func requestInfoFromContext(ctx context.Context) (*requestInfo, bool) {
+	info, ok := ctx.Value(requestInfoContextKey).(*requestInfo)
+	return info, ok
+}
+
+// newLogger builds the process logger. format is "json" or "text"; json
+// is the default outside of dev.
+func newLogger(w io.Writer, format, level, platform string) (*slog.Logger, error) {
+	var slogLevel slog.Level
+	if level != "" {
+		if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error")
+		}
+	}
+	if format == "" {
+		format = "json"
+		if platform == "dev" {
+			format = "text"
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: slogLevel}
+	switch format {
+	case "json":
+		return slog.New(requestIDHandler{slog.NewJSONHandler(w, options)}), nil
+	case "text":
+		return slog.New(requestIDHandler{slog.NewTextHandler(w, options)}), nil
+	default:
+		return nil, fmt.Errorf("LOG_FORMAT must be json or text")
+	}
+}
+
+// requestIDHandler adds the request_id of the request a record was logged
+// under, so anything a handler logs with its request's context can be
+// matched up with the request log line.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info, ok := requestInfoFromContext(ctx); ok {
+		record.AddAttrs(slog.String("request_id", info.ID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// middlewareRequestLog gives every request an ID, taken from X-Request-ID
+// when the caller sent a usable one, echoes it back and logs one line per
+// request once it is done. Server errors are logged at error level with
+// the message the handler responded with and, when it used
+// respondWithServerError, the error behind it.
+func middlewareRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		info := &requestInfo{ID: req.Header.Get("X-Request-ID")}
+		if !requestIDPattern.MatchString(info.ID) {
+			info.ID = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", info.ID)
+
+		recorder := newStatusRecorder(w)
+		req = req.WithContext(context.WithValue(req.Context(), requestInfoContextKey, info))
+		next.ServeHTTP(recorder, req)
+
+		// request_id comes from the logger, see requestIDHandler
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("path", req.URL.Path),
+			slog.String("route", strings.TrimPrefix(req.Pattern, req.Method+" ")),
+			slog.Int("status", recorder.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", recorder.bytes),
+		}
+		if info.UserID != uuid.Nil {
+			attrs = append(attrs, slog.String("user_id", info.UserID.String()))
+		}
+		level := slog.LevelInfo
+		if recorder.status >= 500 {
+			level = slog.LevelError
+			attrs = append(attrs, slog.String("error", recorder.errorMessage))
+			if recorder.cause != nil {
+				attrs = append(attrs, slog.String("cause", recorder.cause.Error()))
+			}
+		}
+		slog.LogAttrs(req.Context(), level, "request", attrs...)
+	})
+}
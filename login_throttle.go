@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/database"
+)
+
+// loginThrottlePolicy locks a key out once it reaches threshold failures,
+// doubling the lockout with every failure after that up to maxLockout.
+// clearOnSuccess resets the key when a login succeeds; otherwise a success
+// only takes back its own attempt, so one good login from a shared address
+// doesn't forgive everyone else's failures.
+type loginThrottlePolicy struct {
+	threshold      int32
+	baseLockout    time.Duration
+	maxLockout     time.Duration
+	clearOnSuccess bool
+}
+
+var (
+	accountThrottle = loginThrottlePolicy{threshold: 5, baseLockout: 30 * time.Second, maxLockout: time.Hour, clearOnSuccess: true}
+	ipThrottle      = loginThrottlePolicy{threshold: 20, baseLockout: 30 * time.Second, maxLockout: time.Hour}
+)
+
+func (p loginThrottlePolicy) lockout(failures int32) time.Duration {
+	if failures < p.threshold {
+		return 0
+	}
+	lockout := p.baseLockout << min(failures-p.threshold, 16)
+	return min(lockout, p.maxLockout)
+}
+
+// retryAfter says how long the caller has to wait given the throttle row
+// claimLoginAttempts returned, or zero when the attempt may go ahead.
+// Attempts past the threshold are refused even before the failures that
+// got there have set a lockout, which is what stops a burst of parallel
+// guesses from all reaching the password check.
+func (p loginThrottlePolicy) retryAfter(throttle database.LoginThrottle, now time.Time) time.Duration {
+	if isLocked(throttle, now) {
+		return throttle.LockedUntil.Time.Sub(now)
+	}
+	if throttle.Failures > p.threshold {
+		return p.lockout(throttle.Failures)
+	}
+	return 0
+}
+
+func isLocked(throttle database.LoginThrottle, now time.Time) bool {
+	return throttle.LockedUntil.Valid && throttle.LockedUntil.Time.After(now)
+}
+
+func accountThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// loginAttempt is one key a login attempt is counted against.
+type loginAttempt struct {
+	key      string
+	policy   loginThrottlePolicy
+	failures int32
+}
+
+// claimLoginAttempts counts the attempt against every key before the
+// password is checked, each in a single statement, and returns how long
+// the caller must wait if any key refuses it. A database error refuses the
+// attempt too, since letting it through would lift the limit.
+func (cfg *apiConfig) claimLoginAttempts(ctx context.Context, attempts ...*loginAttempt) (time.Duration, error) {
+	var wait time.Duration
+	now := time.Now()
+	for _, attempt := range attempts {
+		throttle, err := cfg.db.ClaimLoginAttempt(ctx, attempt.key)
+		if err != nil {
+			return 0, err
+		}
+		attempt.failures = throttle.Failures
+		keyWait := attempt.policy.retryAfter(throttle, now)
+		// an attempt past the threshold locks the key itself, so later
+		// ones are turned away without being counted
+		if keyWait > 0 && !isLocked(throttle, now) {
+			cfg.setLoginLockout(ctx, attempt.key, throttle.Failures, now.Add(keyWait))
+		}
+		wait = max(wait, keyWait)
+	}
+	return wait, nil
+}
+
+// loginFailed locks out every key whose claimed attempt reached its
+// policy's threshold.
+func (cfg *apiConfig) loginFailed(ctx context.Context, attempts ...*loginAttempt) {
+	for _, attempt := range attempts {
+		if lockout := attempt.policy.lockout(attempt.failures); lockout > 0 {
+			cfg.setLoginLockout(ctx, attempt.key, attempt.failures, time.Now().Add(lockout))
+		}
+	}
+}
+
+// loginSucceeded takes back the attempts claimLoginAttempts counted.
+func (cfg *apiConfig) loginSucceeded(ctx context.Context, attempts ...*loginAttempt) {
+	for _, attempt := range attempts {
+		var err error
+		if attempt.policy.clearOnSuccess {
+			err = cfg.db.ClearLoginThrottle(ctx, attempt.key)
+		} else {
+			err = cfg.db.ForgiveLoginAttempt(ctx, attempt.key)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "resetting login throttle failed", "key", attempt.key, "error", err)
+		}
+	}
+}
+
+func (cfg *apiConfig) setLoginLockout(ctx context.Context, key string, failures int32, lockedUntil time.Time) {
+	err := cfg.db.SetLoginLockout(ctx, database.SetLoginLockoutParams{
+		Key:         key,
+		LockedUntil: sql.NullTime{Time: lockedUntil, Valid: true},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "setting login lockout failed", "key", key, "error", err)
+		return
+	}
+	slog.WarnContext(ctx, "login locked out", "key", key, "failures", failures, "locked_until", lockedUntil)
+}
+
+func respondTooManyLoginAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "too many failed login attempts, try again later")
+}
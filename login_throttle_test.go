@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/database"
+)
+
+func TestLoginThrottleLockout(t *testing.T) {
+	policy := loginThrottlePolicy{threshold: 5, baseLockout: 30 * time.Second, maxLockout: time.Hour}
+	tests := []struct {
+		failures int32
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, 30 * time.Second},
+		{6, time.Minute},
+		{7, 2 * time.Minute},
+		{11, 32 * time.Minute},
+		{12, time.Hour},
+		{13, time.Hour},
+		// the shift is capped so huge counts can't overflow
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := policy.lockout(tt.failures); got != tt.want {
+			t.Errorf("lockout(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottleRetryAfter(t *testing.T) {
+	policy := loginThrottlePolicy{threshold: 5, baseLockout: 30 * time.Second, maxLockout: time.Hour}
+	now := time.Now()
+	lockedUntil := func(d time.Duration) sql.NullTime {
+		return sql.NullTime{Time: now.Add(d), Valid: true}
+	}
+	tests := []struct {
+		name     string
+		throttle database.LoginThrottle
+		want     time.Duration
+	}{
+		{"first attempt", database.LoginThrottle{Failures: 1}, 0},
+		{"at the threshold", database.LoginThrottle{Failures: 5}, 0},
+		{"past the threshold before any lockout", database.LoginThrottle{Failures: 6}, time.Minute},
+		{"locked", database.LoginThrottle{Failures: 5, LockedUntil: lockedUntil(20 * time.Second)}, 20 * time.Second},
+		{"lockout expired", database.LoginThrottle{Failures: 1, LockedUntil: lockedUntil(-time.Second)}, 0},
+	}
+	for _, tt := range tests {
+		if got := policy.retryAfter(tt.throttle, now); got != tt.want {
+			t.Errorf("%s: retryAfter = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottleKeys(t *testing.T) {
+	for email, want := range map[string]string{
+		"a@example.com":       "email:a@example.com",
+		"  A@Example.COM\t":   "email:a@example.com",
+		"MixedCase@Host.org ": "email:mixedcase@host.org",
+	} {
+		if got := accountThrottleKey(email); got != want {
+			t.Errorf("accountThrottleKey(%q) = %q, want %q", email, got, want)
+		}
+	}
+
+	for remoteAddr, want := range map[string]string{
+		"192.0.2.1:4242":     "ip:192.0.2.1",
+		"[2001:db8::1]:4242": "ip:2001:db8::1",
+		"192.0.2.1":          "ip:192.0.2.1",
+	} {
+		req := httptest.NewRequest("POST", "/api/login", nil)
+		req.RemoteAddr = remoteAddr
+		if got := ipThrottleKey(req); got != want {
+			t.Errorf("ipThrottleKey(%q) = %q, want %q", remoteAddr, got, want)
+		}
+	}
+}
@@ -1,84 +1,349 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/chichigami/chirpy/internal/auth"
+	"github.com/chichigami/chirpy/internal/config"
 	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/entitlements"
+	"github.com/chichigami/chirpy/internal/mailer"
+	"github.com/chichigami/chirpy/internal/passkey"
+	"github.com/chichigami/chirpy/internal/sociallogin"
+	"github.com/chichigami/chirpy/internal/tracing"
+	"github.com/chichigami/chirpy/internal/webhooks"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 func main() {
 	godotenv.Load()
-	dbURL := os.Getenv("DB_URL")
-	if dbURL == "" {
-		log.Fatal("DB_URL must be set")
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-	dbConnection, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%s", err)
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "print-config":
+			cfg.Print(os.Stdout)
+			return
+		case "bootstrap-admin":
+			if len(args) != 2 {
+				log.Fatal("usage: chirpy bootstrap-admin <email>")
+			}
+			if cfg.DBURL == "" {
+				log.Fatal("DB_URL must be set")
+			}
+			dbConnection, err := sql.Open("postgres", cfg.DBURL)
+			if err != nil {
+				log.Fatalf("Error opening database: %s", err)
+			}
+			defer dbConnection.Close()
+			if err := bootstrapAdmin(context.Background(), database.New(dbConnection), args[1]); err != nil {
+				log.Fatalf("Error bootstrapping admin: %s", err)
+			}
+			log.Printf("%s is now an admin", args[1])
+			return
+		default:
+			log.Fatalf("unknown command %q", args[0])
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%s", err)
+	}
+
+	logger, err := newLogger(os.Stderr, cfg.LogFormat, cfg.LogLevel, cfg.Platform)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// also routes the standard log package through slog
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.OTELTracesExporter)
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %s", err)
+	}
+
+	dbConnection, err := sql.Open("postgres", cfg.DBURL)
 	if err != nil {
 		log.Fatalf("Error opening database: %s", err)
 	}
-	dbQueries := database.New(dbConnection)
+	dbQueries := database.New(tracing.WrapDB(dbConnection))
 
-	const port = "8080"
+	appMailer, err := newMailer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	webAuthn, err := passkey.New(cfg.WebauthnRPID, "Chirpy", cfg.WebauthnRPOrigins)
+	if err != nil {
+		log.Fatalf("Error configuring webauthn: %s", err)
+	}
 
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM must be set")
+	socialProviders, err := newSocialProviders(context.Background(), cfg.OIDC)
+	if err != nil {
+		log.Fatal(err)
 	}
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET must be set")
+
+	passwordPolicy := auth.PasswordPolicy{MinLength: cfg.PasswordMinLength, MinStrength: cfg.PasswordMinStrength}
+	if cfg.BreachedPasswordsDir != "" {
+		passwordPolicy.Breached = &auth.BreachedPasswords{Dir: cfg.BreachedPasswordsDir}
 	}
-	polkaSecret := os.Getenv("POLKA_KEY")
-	if polkaSecret == "" {
-		log.Fatal("POLKA_KEY must be set")
+
+	plans := entitlements.Default
+	if cfg.EntitlementsFile != "" {
+		plans, err = entitlements.Load(cfg.EntitlementsFile)
+		if err != nil {
+			log.Fatalf("Error loading entitlements: %s", err)
+		}
 	}
 
 	apiCfg := apiConfig{
-		db:        dbQueries,
-		platform:  platform,
-		jwtSecret: jwtSecret,
-		polka:     polkaSecret,
+		db:                   dbQueries,
+		sqlDB:                dbConnection,
+		platform:             cfg.Platform,
+		jwtSecret:            cfg.JWTSecret,
+		accessTokenTTL:       cfg.AccessTokenTTL,
+		refreshTokenTTL:      cfg.RefreshTokenTTL,
+		oauthAccessTokenTTL:  cfg.OAuthAccessTokenTTL,
+		oauthRefreshTokenTTL: cfg.OAuthRefreshTokenTTL,
+		polka:                cfg.PolkaKey,
+		polkaSecrets:         cfg.PolkaWebhookSecrets,
+		mailer:               appMailer,
+		webAuthn:             webAuthn,
+		passwordPolicy:       passwordPolicy,
+		socialProviders:      socialProviders,
+		plans:                plans,
+		webhookClient:        webhooks.NewClient(cfg.Platform == "dev"),
+		metrics:              newAppMetrics(dbConnection),
 	}
 	mux := http.NewServeMux()
 
-	mux.Handle("/app/", http.StripPrefix("/app", apiCfg.middlewareMetricInc(http.FileServer(http.FileSystem(http.Dir("."))))))
+	mux.Handle("/app/", http.StripPrefix("/app", http.FileServer(http.FileSystem(http.Dir(".")))))
 
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetric)
-	mux.HandleFunc("POST /admin/reset", apiCfg.handlerMetricReset)
-	mux.HandleFunc("GET /api/healthz", handlerHealthz)
+	mux.Handle("GET /metrics", apiCfg.metrics.handler(cfg.MetricsToken))
+	mux.HandleFunc("POST /admin/reset", apiCfg.middlewareRole(roleAdmin, apiCfg.handlerMetricReset))
+	mux.HandleFunc("DELETE /admin/chirps/{chirpID}", apiCfg.middlewareRole(roleModerator, apiCfg.handlerAdminChirpsDelete))
+	mux.HandleFunc("GET /admin/webhooks", apiCfg.middlewareRole(roleAdmin, apiCfg.handlerAdminWebhooksList))
+	mux.HandleFunc("POST /admin/webhooks/{id}/replay", apiCfg.middlewareRole(roleAdmin, apiCfg.handlerAdminWebhookReplay))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", apiCfg.middlewareRole(roleAdmin, apiCfg.handlerAdminSetRole))
+	mux.HandleFunc("GET /api/healthz", handlerLivez)
+	mux.HandleFunc("GET /api/livez", handlerLivez)
+	mux.HandleFunc("GET /api/readyz", apiCfg.handlerReadyz)
 
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerUsersUpdate)
+	mux.HandleFunc("PUT /api/users", apiCfg.middlewareAuth(apiCfg.handlerUsersUpdate))
+	mux.HandleFunc("PATCH /api/users", apiCfg.middlewareAuth(apiCfg.handlerUsersUpdate))
+	mux.HandleFunc("DELETE /api/users", apiCfg.middlewareAuth(apiCfg.handlerUsersDelete))
+	mux.HandleFunc("GET /api/users/export", apiCfg.middlewareAuth(apiCfg.handlerUsersExport))
+	mux.HandleFunc("GET /api/users/entitlements", apiCfg.middlewareAuth(apiCfg.handlerEntitlements))
+	mux.HandleFunc("GET /api/users/{userID}", apiCfg.handlerUsersGetID)
+	mux.HandleFunc("GET /api/users/by-handle/{handle}", apiCfg.handlerUsersGetHandle)
+	mux.HandleFunc("PATCH /api/users/profile", apiCfg.middlewareAuth(apiCfg.handlerUsersProfileUpdate))
+	mux.HandleFunc("POST /api/users/email/confirm", apiCfg.handlerUsersEmailConfirm)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerUsersLogin)
+	mux.HandleFunc("POST /api/login/2fa", apiCfg.handlerLoginTwoFactor)
+	mux.HandleFunc("POST /api/users/2fa/enroll", apiCfg.middlewareAuth(apiCfg.handlerTwoFactorEnroll))
+	mux.HandleFunc("POST /api/users/2fa/confirm", apiCfg.middlewareAuth(apiCfg.handlerTwoFactorConfirm))
+	mux.HandleFunc("POST /api/webauthn/register/begin", apiCfg.middlewareAuth(apiCfg.handlerWebauthnRegisterBegin))
+	mux.HandleFunc("POST /api/webauthn/register/finish/{sessionID}", apiCfg.middlewareAuth(apiCfg.handlerWebauthnRegisterFinish))
+	mux.HandleFunc("POST /api/webauthn/login/begin", apiCfg.handlerWebauthnLoginBegin)
+	mux.HandleFunc("POST /api/webauthn/login/finish/{sessionID}", apiCfg.handlerWebauthnLoginFinish)
+	mux.HandleFunc("GET /api/auth/{provider}/start", apiCfg.handlerSocialLoginStart)
+	mux.HandleFunc("GET /api/auth/{provider}/callback", apiCfg.handlerSocialLoginCallback)
+	mux.HandleFunc("POST /api/oauth/clients", apiCfg.middlewareAuth(apiCfg.handlerOAuthClientsCreate))
+	mux.HandleFunc("GET /api/oauth/authorize", apiCfg.middlewareAuth(apiCfg.handlerOAuthAuthorizeInfo))
+	mux.HandleFunc("POST /api/oauth/authorize", apiCfg.middlewareAuth(apiCfg.handlerOAuthAuthorize))
+	mux.HandleFunc("POST /api/oauth/token", apiCfg.handlerOAuthToken)
+	mux.HandleFunc("POST /api/oauth/revoke", apiCfg.handlerOAuthRevoke)
+	mux.HandleFunc("POST /api/oauth/introspect", apiCfg.handlerOAuthIntrospect)
+	mux.HandleFunc("POST /api/users/verify", apiCfg.handlerUsersVerify)
+	mux.HandleFunc("POST /api/users/verify/resend", apiCfg.middlewareAuth(apiCfg.handlerUsersVerifyResend))
+
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerPasswordReset)
 
-	mux.HandleFunc("GET /api/chirps", apiCfg.handlerChirpsGetAll)
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirpsCreate)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerChirpsGetID)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDeleteID)
+	mux.HandleFunc("GET /api/chirps", apiCfg.middlewareOptionalAuth(apiCfg.handlerChirpsGetAll))
+	mux.HandleFunc("POST /api/chirps", apiCfg.middlewareScopedAuth("chirps:write", apiCfg.handlerChirpsCreate))
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.middlewareScopedAuth("chirps:write", apiCfg.handlerChirpsUpdate))
+	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.middlewareOptionalAuth(apiCfg.handlerChirpsGetID))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.middlewareScopedAuth("chirps:write", apiCfg.handlerChirpsDeleteID))
 
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefreshToken)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevokeRefreshToken)
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhook)
 
+	mux.HandleFunc("POST /api/webhooks", apiCfg.middlewareAuth(apiCfg.handlerWebhookEndpointsCreate))
+	mux.HandleFunc("GET /api/webhooks", apiCfg.middlewareAuth(apiCfg.handlerWebhookEndpointsList))
+	mux.HandleFunc("DELETE /api/webhooks/{id}", apiCfg.middlewareAuth(apiCfg.handlerWebhookEndpointsDelete))
+	mux.HandleFunc("GET /api/webhooks/{id}/deliveries", apiCfg.middlewareAuth(apiCfg.handlerWebhookDeliveriesList))
+	mux.HandleFunc("GET /api/webhooks/{id}/deliveries/{deliveryID}/attempts", apiCfg.middlewareAuth(apiCfg.handlerWebhookAttemptsList))
+
+	// SIGINT or SIGTERM cancels ctx, which starts the shutdown below
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var jobs sync.WaitGroup
+	runJob := func(job func(context.Context, time.Duration), interval time.Duration) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job(ctx, interval)
+		}()
+	}
+	runJob(apiCfg.purgeDeletedAccounts, time.Hour)
+	runJob(apiCfg.expireSubscriptions, time.Hour)
+	runJob(apiCfg.runWebhookDispatcher, 5*time.Second)
+
 	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:              ":" + strconv.Itoa(cfg.Port),
+		Handler:           serverHandler(mux, apiCfg.metrics),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("serving", "port", cfg.Port, "platform", cfg.Platform)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		slog.Error("server stopped", "error", err)
+		exitCode = 1
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	}
+	// a second signal kills the process instead of waiting for the drain
+	stop()
+
+	// fail readiness first so load balancers stop sending new requests
+	// before the listener closes
+	apiCfg.draining.Store(true)
+	if exitCode == 0 {
+		time.Sleep(cfg.ShutdownDrainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("requests did not finish in time", "error", err)
+		server.Close()
+	}
+	jobs.Wait()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("flushing traces failed", "error", err)
+	}
+	if err := dbConnection.Close(); err != nil {
+		slog.Error("closing database failed", "error", err)
+	}
+	slog.Info("stopped")
+	if exitCode != 0 {
+		os.Exit(exitCode)
 	}
-	log.Printf("Serving on port: %s\n", port)
-	server.ListenAndServe()
 }
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	db             *database.Queries
-	platform       string
-	jwtSecret      string
-	polka          string
+	db                   *database.Queries
+	sqlDB                *sql.DB
+	draining             atomic.Bool
+	platform             string
+	jwtSecret            string
+	accessTokenTTL       time.Duration
+	refreshTokenTTL      time.Duration
+	oauthAccessTokenTTL  time.Duration
+	oauthRefreshTokenTTL time.Duration
+	polka                string
+	polkaSecrets         []string
+	mailer               mailer.Mailer
+	webAuthn             *webauthn.WebAuthn
+	passwordPolicy       auth.PasswordPolicy
+	socialProviders      map[string]*sociallogin.Provider
+	plans                entitlements.Plans
+	webhookClient        *webhooks.Client
+	metrics              *appMetrics
+}
+
+// serverHandler wraps mux in the middleware every request goes through.
+// tracing.Route has to stay directly around the mux, see its doc.
+func serverHandler(mux *http.ServeMux, metrics *appMetrics) http.Handler {
+	return tracing.Handler(middlewareRequestLog(metrics.middleware(tracing.Route(mux))))
+}
+
+// inTx runs fn against queries bound to one transaction, committing when fn
+// succeeds and rolling back otherwise.
+func (cfg *apiConfig) inTx(ctx context.Context, fn func(*database.Queries) error) error {
+	tx, err := cfg.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(database.New(tracing.WrapDB(tx))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// newMailer picks the mail transport from MAILER. Without it, dev platforms
+// write mail to MAIL_DIR; Validate refuses to start anything else.
+func newMailer(cfg *config.Config) (mailer.Mailer, error) {
+	kind := cfg.Mailer
+	if kind == "" && cfg.Platform == "dev" {
+		kind = "file"
+	}
+
+	switch kind {
+	case "smtp":
+		return &mailer.SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}, nil
+	case "file":
+		return &mailer.FileMailer{Dir: cfg.MailDir, From: cfg.MailFrom}, nil
+	case "memory":
+		return &mailer.MemoryMailer{}, nil
+	default:
+		return nil, fmt.Errorf("MAILER must be one of smtp, file or memory")
+	}
+}
+
+// newSocialProviders sets up every configured identity provider, which
+// config.Validate has already checked.
+func newSocialProviders(ctx context.Context, configs map[string]*config.OIDCProvider) (map[string]*sociallogin.Provider, error) {
+	providers := map[string]*sociallogin.Provider{}
+	for name, c := range configs {
+		provider, err := sociallogin.NewProvider(ctx, name, c.Issuer, c.ClientID, c.ClientSecret, c.RedirectURL)
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = provider
+	}
+	return providers, nil
 }
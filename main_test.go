@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestServerHandlerNamesSpanAndLogsRoute runs a request through the same
+// middleware chain main serves with.
+func TestServerHandlerNamesSpanAndLogsRoute(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previousProvider) })
+
+	logs := &bytes.Buffer{}
+	previousLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previousLogger) })
+
+	// sql.Open doesn't connect, and nothing here scrapes the pool stats
+	db, err := sql.Open("postgres", "postgres://localhost/chirpy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/1", nil)
+	serverHandler(mux, newAppMetrics(db)).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if spans[0].Name() != "GET /api/chirps/{chirpID}" {
+		t.Errorf("span name = %q, want the route pattern", spans[0].Name())
+	}
+
+	var line struct {
+		Route string `json:"route"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("decoding request log %q: %s", logs, err)
+	}
+	if line.Route != "/api/chirps/{chirpID}" {
+		t.Errorf("logged route = %q", line.Route)
+	}
+}
+
+func TestRequestLogRecordsServerErrorCause(t *testing.T) {
+	logs := &bytes.Buffer{}
+	previousLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previousLogger) })
+
+	handler := middlewareRequestLog(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		respondWithServerError(w, "fetching user failed", errors.New("pq: connection refused"))
+	}))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/users/1", nil))
+
+	if strings.Contains(resp.Body.String(), "connection refused") {
+		t.Errorf("response leaks the cause: %s", resp.Body)
+	}
+	var line struct {
+		Level string `json:"level"`
+		Error string `json:"error"`
+		Cause string `json:"cause"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("decoding request log %q: %s", logs, err)
+	}
+	if line.Level != "ERROR" || line.Error != "fetching user failed" || line.Cause != "pq: connection refused" {
+		t.Errorf("request log = %s", logs)
+	}
+}
+
+func TestHandlerLogsCarryRequestID(t *testing.T) {
+	logs := &bytes.Buffer{}
+	logger, err := newLogger(logs, "json", "", "production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousLogger := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previousLogger) })
+
+	handler := middlewareRequestLog(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		slog.ErrorContext(req.Context(), "sending email failed", "error", errors.New("smtp: timeout"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/api/users", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	decoder := json.NewDecoder(logs)
+	for _, msg := range []string{"sending email failed", "request"} {
+		var line struct {
+			Msg       string `json:"msg"`
+			RequestID string `json:"request_id"`
+		}
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatalf("decoding log line: %s", err)
+		}
+		if line.Msg != msg || line.RequestID != "req-123" {
+			t.Errorf("log line %+v, want %q with request_id req-123", line, msg)
+		}
+	}
+}
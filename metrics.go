@@ -1,30 +1,160 @@
 package main
 
 import (
-	"fmt"
+	"crypto/subtle"
+	"database/sql"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func (cfg *apiConfig) middlewareMetricInc(next http.Handler) http.Handler {
+// appMetrics holds every Prometheus collector chirpy exports on /metrics.
+// It uses its own registry rather than the global one so nothing a
+// dependency registers ends up in the output by accident.
+type appMetrics struct {
+	registry         *prometheus.Registry
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+	chirpsCreated    prometheus.Counter
+	logins           *prometheus.CounterVec
+	webhookEvents    *prometheus.CounterVec
+	webhookDelivered *prometheus.CounterVec
+}
+
+func newAppMetrics(db *sql.DB) *appMetrics {
+	m := &appMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_http_requests_total",
+			Help: "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chirpy_http_request_duration_seconds",
+			Help:    "Time spent serving HTTP requests by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "chirpy_http_requests_in_flight",
+			Help: "HTTP requests currently being served.",
+		}),
+		chirpsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chirpy_chirps_created_total",
+			Help: "Chirps created.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_logins_total",
+			Help: "Successful logins by method.",
+		}, []string{"method"}),
+		webhookEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_webhook_events_total",
+			Help: "Incoming webhook events processed by source and outcome.",
+		}, []string{"source", "outcome"}),
+		webhookDelivered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_webhook_delivery_attempts_total",
+			Help: "Outgoing webhook delivery attempts by resulting status.",
+		}, []string{"status"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "chirpy"),
+		m.requests,
+		m.requestDuration,
+		m.requestsInFlight,
+		m.chirpsCreated,
+		m.logins,
+		m.webhookEvents,
+		m.webhookDelivered,
+	)
+	return m
+}
+
+// handler serves the registry in the Prometheus text format. Scrapers have
+// to send token as a bearer token; config.Validate only lets it be empty on
+// dev.
+func (m *appMetrics) handler(token string) http.Handler {
+	metrics := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		cfg.fileserverHits.Add(1)
-		next.ServeHTTP(w, req)
+		if token != "" {
+			got, err := auth.GetBearerToken(req.Header)
+			if err != nil || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				respondWithError(w, http.StatusUnauthorized, "invalid metrics token")
+				return
+			}
+		}
+		metrics.ServeHTTP(w, req)
 	})
 }
 
-func (cfg *apiConfig) handlerMetric(w http.ResponseWriter, req *http.Request) {
-	result := fmt.Sprintf(`
-	<html>
-		<body>
-			<h1>Welcome, Chirpy Admin</h1>
-			<p>Chirpy has been visited %d times!</p>
-		</body>
-	</html>
-	`, cfg.fileserverHits.Load())
+// middleware records every request under the ServeMux pattern that handled
+// it rather than the raw path, so ids in paths don't explode the number of
+// series. Requests no pattern matched share one label.
+func (m *appMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		m.requestsInFlight.Inc()
+		defer m.requestsInFlight.Dec()
+
+		start := time.Now()
+		recorder := newStatusRecorder(w)
+		next.ServeHTTP(recorder, req)
+
+		// the mux sets Pattern on the request once it has picked a handler
+		route := req.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requests.WithLabelValues(route, req.Method, strconv.Itoa(recorder.status)).Inc()
+		m.requestDuration.WithLabelValues(route, req.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder remembers what a handler wrote so middleware can report
+// on it. It is shared by every middleware in the chain, see
+// newStatusRecorder.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+	// errorMessage is what respondWithError sent with a 5xx response and
+	// cause the error respondWithServerError was given.
+	errorMessage string
+	cause        error
+}
+
+// newStatusRecorder wraps w, or returns w itself when an outer middleware
+// already wrapped it.
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	if recorder, ok := w.(*statusRecorder); ok {
+		return recorder
+	}
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
 
-	w.Header().Set("Content-Type", "text/html")
-	w.WriteHeader(200)
-	w.Write([]byte(result))
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
 }
 
 func (cfg *apiConfig) handlerMetricReset(w http.ResponseWriter, req *http.Request) {
@@ -33,5 +163,4 @@ func (cfg *apiConfig) handlerMetricReset(w http.ResponseWriter, req *http.Reques
 		return
 	}
 	cfg.db.DeleteAllUsers(req.Context())
-	cfg.fileserverHits.Store(0)
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/chichigami/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+type contextKey string
+
+const authUserContextKey contextKey = "authUser"
+
+var errNoAuthHeader = errors.New("authorization header is missing")
+
+// authUser is the caller identity the auth middleware attaches to a request.
+// ClientID is set when the token was issued to a third-party OAuth client,
+// which may then only use the scopes the user granted it.
+type authUser struct {
+	ID       uuid.UUID
+	ClientID string
+	Scopes   []string
+}
+
+func (u authUser) hasScope(scope string) bool {
+	if u.ClientID == "" {
+		return true
+	}
+	return slices.Contains(u.Scopes, scope)
+}
+
+func authUserFromContext(ctx context.Context) (authUser, bool) {
+	user, ok := ctx.Value(authUserContextKey).(authUser)
+	return user, ok
+}
+
+// middlewareAuth rejects requests without a valid first-party access token.
+// Tokens issued to OAuth clients are refused so third-party apps can't
+// manage the account itself.
+func (cfg *apiConfig) middlewareAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, err := cfg.authenticate(req)
+		if err != nil {
+			respondUnauthorized(w, err)
+			return
+		}
+		if user.ClientID != "" {
+			respondInsufficientScope(w, "")
+			return
+		}
+		next(w, req.WithContext(context.WithValue(req.Context(), authUserContextKey, user)))
+	}
+}
+
+// middlewareScopedAuth is middlewareAuth for endpoints OAuth clients may
+// call when the user granted them scope.
+func (cfg *apiConfig) middlewareScopedAuth(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, err := cfg.authenticate(req)
+		if err != nil {
+			respondUnauthorized(w, err)
+			return
+		}
+		if !user.hasScope(scope) {
+			respondInsufficientScope(w, scope)
+			return
+		}
+		next(w, req.WithContext(context.WithValue(req.Context(), authUserContextKey, user)))
+	}
+}
+
+// middlewareOptionalAuth lets anonymous requests through, but still rejects
+// requests that send an Authorization header that does not validate.
+func (cfg *apiConfig) middlewareOptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") == "" {
+			next(w, req)
+			return
+		}
+		user, err := cfg.authenticate(req)
+		if err != nil {
+			respondUnauthorized(w, err)
+			return
+		}
+		next(w, req.WithContext(context.WithValue(req.Context(), authUserContextKey, user)))
+	}
+}
+
+func (cfg *apiConfig) authenticate(req *http.Request) (authUser, error) {
+	if req.Header.Get("Authorization") == "" {
+		return authUser{}, errNoAuthHeader
+	}
+	jwtToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		return authUser{}, err
+	}
+	claims, err := auth.ParseJWT(jwtToken, cfg.jwtSecret)
+	if err != nil {
+		return authUser{}, err
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return authUser{}, err
+	}
+	if info, ok := requestInfoFromContext(req.Context()); ok {
+		info.UserID = userID
+	}
+	return authUser{
+		ID:       userID,
+		ClientID: claims.ClientID,
+		Scopes:   strings.Fields(claims.Scope),
+	}, nil
+}
+
+func respondUnauthorized(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNoAuthHeader) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy"`)
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="chirpy", error="invalid_token", error_description=%q`, err.Error()))
+	respondWithError(w, http.StatusUnauthorized, err.Error())
+}
+
+func respondInsufficientScope(w http.ResponseWriter, scope string) {
+	if scope == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy", error="insufficient_scope"`)
+		respondWithError(w, http.StatusForbidden, "endpoint is not available to third-party apps")
+		return
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="chirpy", error="insufficient_scope", scope=%q`, scope))
+	respondWithError(w, http.StatusForbidden, "token is missing scope "+scope)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+const testJWTSecret = "test-secret"
+
+func testToken(t *testing.T, userID uuid.UUID, clientID, scope string) string {
+	t.Helper()
+	var token string
+	var err error
+	if clientID == "" {
+		token, err = auth.MakeJWT(userID, testJWTSecret, time.Hour)
+	} else {
+		token, err = auth.MakeClientJWT(userID, testJWTSecret, time.Hour, clientID, scope)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// echoUser responds 200 with the authenticated user's id, or "anonymous".
+func echoUser(w http.ResponseWriter, req *http.Request) {
+	user, ok := authUserFromContext(req.Context())
+	if !ok {
+		w.Write([]byte("anonymous"))
+		return
+	}
+	w.Write([]byte(user.ID.String()))
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	cfg := &apiConfig{jwtSecret: testJWTSecret}
+	userID := uuid.New()
+	firstParty := testToken(t, userID, "", "")
+	writeClient := testToken(t, userID, "client-1", "chirps:write")
+	readClient := testToken(t, userID, "client-1", "")
+	otherSecret, err := auth.MakeJWT(userID, "other-secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		authorization string
+		wantStatus    int
+		wantBody      string
+		// wantChallenge is a substring of the WWW-Authenticate header
+		wantChallenge string
+	}{
+		{"auth without token", cfg.middlewareAuth(echoUser), "", 401, "", `Bearer realm="chirpy"`},
+		{"auth with malformed header", cfg.middlewareAuth(echoUser), "Token abc", 401, "", `error="invalid_token"`},
+		{"auth with bad signature", cfg.middlewareAuth(echoUser), "Bearer " + otherSecret, 401, "", `error="invalid_token"`},
+		{"auth with first-party token", cfg.middlewareAuth(echoUser), "Bearer " + firstParty, 200, userID.String(), ""},
+		{"auth with client token", cfg.middlewareAuth(echoUser), "Bearer " + writeClient, 403, "", `error="insufficient_scope"`},
+		{"scoped with first-party token", cfg.middlewareScopedAuth("chirps:write", echoUser), "Bearer " + firstParty, 200, userID.String(), ""},
+		{"scoped with granted scope", cfg.middlewareScopedAuth("chirps:write", echoUser), "Bearer " + writeClient, 200, userID.String(), ""},
+		{"scoped without the scope", cfg.middlewareScopedAuth("chirps:write", echoUser), "Bearer " + readClient, 403, "", `scope="chirps:write"`},
+		{"scoped without token", cfg.middlewareScopedAuth("chirps:write", echoUser), "", 401, "", `Bearer realm="chirpy"`},
+		{"optional without token", cfg.middlewareOptionalAuth(echoUser), "", 200, "anonymous", ""},
+		{"optional with token", cfg.middlewareOptionalAuth(echoUser), "Bearer " + firstParty, 200, userID.String(), ""},
+		{"optional with invalid token", cfg.middlewareOptionalAuth(echoUser), "Bearer nope", 401, "", `error="invalid_token"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			resp := httptest.NewRecorder()
+			tt.handler(resp, req)
+
+			if resp.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", resp.Code, tt.wantStatus, resp.Body)
+			}
+			if tt.wantBody != "" && resp.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", resp.Body, tt.wantBody)
+			}
+			challenge := resp.Header().Get("WWW-Authenticate")
+			if tt.wantChallenge != "" && !strings.Contains(challenge, tt.wantChallenge) {
+				t.Errorf("WWW-Authenticate = %q, want it to contain %q", challenge, tt.wantChallenge)
+			}
+			if tt.wantChallenge == "" && challenge != "" {
+				t.Errorf("unexpected WWW-Authenticate %q", challenge)
+			}
+		})
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
@@ -10,13 +10,34 @@ func respondWithError(w http.ResponseWriter, code int, msg string) {
 	type ErrorResponse struct {
 		Error string `json:"error"`
 	}
+	// the request log reports server errors with their message
+	if recorder, ok := w.(*statusRecorder); ok && code >= 500 {
+		recorder.errorMessage = msg
+	}
 	respondWithJSON(w, code, ErrorResponse{Error: msg})
 }
 
+// respondWithServerError sends msg with a 500 and hands err, which the
+// client never sees, to the request log as the cause.
+func respondWithServerError(w http.ResponseWriter, msg string, err error) {
+	if recorder, ok := w.(*statusRecorder); ok {
+		recorder.cause = err
+	}
+	respondWithError(w, http.StatusInternalServerError, msg)
+}
+
+func respondWithValidationError(w http.ResponseWriter, msg string, problems interface{}) {
+	type ValidationErrorResponse struct {
+		Error    string      `json:"error"`
+		Problems interface{} `json:"problems"`
+	}
+	respondWithJSON(w, http.StatusBadRequest, ValidationErrorResponse{Error: msg, Problems: problems})
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	data, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshaling JSON %s", err)
+		slog.Error("encoding response failed", "error", err)
 		w.WriteHeader(500)
 		return
 	}
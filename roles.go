@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	roleUser      = "user"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
+// roleRank orders the roles so that each one includes the ones below it.
+var roleRank = map[string]int{
+	roleUser:      0,
+	roleModerator: 1,
+	roleAdmin:     2,
+}
+
+// middlewareRole only lets through first-party access tokens whose user has
+// at least role. The role is read from the database on every request so a
+// revoked role takes effect immediately rather than when the JWT expires.
+func (cfg *apiConfig) middlewareRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.middlewareAuth(func(w http.ResponseWriter, req *http.Request) {
+		user, _ := authUserFromContext(req.Context())
+		userRole, err := cfg.db.GetUserRole(req.Context(), user.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondUnauthorized(w, errors.New("user no longer exists"))
+			return
+		}
+		if err != nil {
+			respondWithServerError(w, "fetching role failed", err)
+			return
+		}
+		if roleRank[userRole] < roleRank[role] {
+			respondWithError(w, http.StatusForbidden, "requires the "+role+" role")
+			return
+		}
+		next(w, req)
+	})
+}
+
+func (cfg *apiConfig) handlerAdminSetRole(w http.ResponseWriter, req *http.Request) {
+	type parameter struct {
+		Role string `json:"role"`
+	}
+	type response struct {
+		ID   uuid.UUID `json:"id"`
+		Role string    `json:"role"`
+	}
+
+	admin, _ := authUserFromContext(req.Context())
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "invalid user id")
+		return
+	}
+	param := parameter{}
+	decoder := json.NewDecoder(req.Body)
+	if decodeErr := decoder.Decode(&param); decodeErr != nil {
+		respondWithError(w, 400, decodeErr.Error())
+		return
+	}
+	if _, ok := roleRank[param.Role]; !ok {
+		respondWithError(w, 400, "role must be user, moderator or admin")
+		return
+	}
+	// keep at least the caller able to manage roles
+	if userID == admin.ID && param.Role != roleAdmin {
+		respondWithError(w, 400, "admins cannot remove their own admin role")
+		return
+	}
+
+	updated, err := cfg.db.SetUserRole(req.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: param.Role,
+	})
+	if err != nil {
+		respondWithServerError(w, "updating role failed", err)
+		return
+	}
+	if updated == 0 {
+		respondWithError(w, 404, "user cannot be found")
+		return
+	}
+	slog.InfoContext(req.Context(), "role changed", "admin_id", admin.ID, "user_id", userID, "role", param.Role)
+	respondWithJSON(w, http.StatusOK, response{ID: userID, Role: param.Role})
+}
+
+// bootstrapAdmin makes the account with email an admin. It backs the
+// "bootstrap-admin" command used to create the first admin, since granting
+// roles over the API already needs one.
+func bootstrapAdmin(ctx context.Context, db *database.Queries, email string) error {
+	dbUser, err := db.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user with email %s", email)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = db.SetUserRole(ctx, database.SetUserRoleParams{
+		ID:   dbUser.ID,
+		Role: roleAdmin,
+	})
+	return err
+}
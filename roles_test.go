@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestMiddlewareRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		userRole   string // "" means the user no longer exists
+		required   string
+		wantStatus int
+	}{
+		{"user on a moderator route", roleUser, roleModerator, 403},
+		{"moderator on a moderator route", roleModerator, roleModerator, 200},
+		{"moderator on an admin route", roleModerator, roleAdmin, 403},
+		{"admin on a moderator route", roleAdmin, roleModerator, 200},
+		{"admin on an admin route", roleAdmin, roleAdmin, 200},
+		{"unknown role on a user route", "banned", roleUser, 200},
+		{"deleted user", "", roleModerator, 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			if tt.userRole == "" {
+				db.returns("GetUserRole")
+			} else {
+				db.returns("GetUserRole", []any{tt.userRole})
+			}
+			cfg := db.config()
+			userID := uuid.New()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+testToken(t, userID, "", ""))
+			resp := httptest.NewRecorder()
+			cfg.middlewareRole(tt.required, echoUser)(resp, req)
+
+			if resp.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", resp.Code, tt.wantStatus, resp.Body)
+			}
+			if calls := db.calledWith("GetUserRole"); len(calls) != 1 || calls[0][0] != userID.String() {
+				t.Errorf("GetUserRole called with %v, want [%s]", calls, userID)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRoleRejectsClientTokens(t *testing.T) {
+	db := newFakeDB(t)
+	db.returns("GetUserRole", []any{roleAdmin})
+	cfg := db.config()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, uuid.New(), "client-1", "chirps:write"))
+	resp := httptest.NewRecorder()
+	cfg.middlewareRole(roleModerator, echoUser)(resp, req)
+
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", resp.Code)
+	}
+	if calls := db.calledWith("GetUserRole"); len(calls) != 0 {
+		t.Errorf("GetUserRole called %d times for a client token", len(calls))
+	}
+}
+
+func TestHandlerAdminSetRole(t *testing.T) {
+	adminID := uuid.New()
+	otherID := uuid.New()
+	tests := []struct {
+		name       string
+		userID     string
+		body       string
+		found      bool
+		wantStatus int
+		wantSet    bool
+	}{
+		{"promote another user", otherID.String(), `{"role":"moderator"}`, true, 200, true},
+		{"demote another admin", otherID.String(), `{"role":"user"}`, true, 200, true},
+		{"missing user", otherID.String(), `{"role":"moderator"}`, false, 404, true},
+		{"unknown role", otherID.String(), `{"role":"owner"}`, true, 400, false},
+		{"invalid user id", "nope", `{"role":"moderator"}`, true, 400, false},
+		{"demote self to moderator", adminID.String(), `{"role":"moderator"}`, true, 400, false},
+		{"demote self to user", adminID.String(), `{"role":"user"}`, true, 400, false},
+		{"keep own admin role", adminID.String(), `{"role":"admin"}`, true, 200, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			db.returns("GetUserRole", []any{roleAdmin})
+			db.on("SetUserRole", func([]driver.Value) ([][]any, int64, error) {
+				if tt.found {
+					return nil, 1, nil
+				}
+				return nil, 0, nil
+			})
+			cfg := db.config()
+
+			req := httptest.NewRequest(http.MethodPut, "/admin/users/"+tt.userID+"/role", strings.NewReader(tt.body))
+			req.SetPathValue("userID", tt.userID)
+			req.Header.Set("Authorization", "Bearer "+testToken(t, adminID, "", ""))
+			resp := httptest.NewRecorder()
+			cfg.middlewareRole(roleAdmin, cfg.handlerAdminSetRole)(resp, req)
+
+			if resp.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", resp.Code, tt.wantStatus, resp.Body)
+			}
+			calls := db.calledWith("SetUserRole")
+			if got := len(calls) == 1; got != tt.wantSet {
+				t.Fatalf("SetUserRole called %d times, want it called: %v", len(calls), tt.wantSet)
+			}
+			if tt.wantSet && calls[0][0] != tt.userID {
+				t.Errorf("SetUserRole updated %v, want %s", calls[0][0], tt.userID)
+			}
+		})
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "root@example.com", Role: roleUser}
+	db := newFakeDB(t)
+	db.returns("GetUserByEmail", userRow(user))
+	db.on("SetUserRole", func([]driver.Value) ([][]any, int64, error) { return nil, 1, nil })
+	cfg := db.config()
+
+	if err := bootstrapAdmin(context.Background(), cfg.db, user.Email); err != nil {
+		t.Fatal(err)
+	}
+	calls := db.calledWith("SetUserRole")
+	if len(calls) != 1 || calls[0][0] != user.ID.String() || calls[0][1] != roleAdmin {
+		t.Errorf("SetUserRole called with %v, want [%s admin]", calls, user.ID)
+	}
+}
+
+func TestBootstrapAdminUnknownEmail(t *testing.T) {
+	db := newFakeDB(t)
+	db.returns("GetUserByEmail")
+	cfg := db.config()
+
+	err := bootstrapAdmin(context.Background(), cfg.db, "nobody@example.com")
+	if err == nil || !strings.Contains(err.Error(), "no user with email nobody@example.com") {
+		t.Errorf("err = %v, want no user with email", err)
+	}
+	if calls := db.calledWith("SetUserRole"); len(calls) != 0 {
+		t.Errorf("SetUserRole called for an unknown email")
+	}
+}
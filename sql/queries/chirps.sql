@@ -34,4 +34,15 @@ WHERE id = $1;
 
 -- name: DeleteChirp :exec
 DELETE FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetRecentChirpActivity :one
+SELECT COUNT(*) AS chirp_count, COALESCE(MIN(created_at), NOW())::TIMESTAMP AS oldest
+FROM chirps
+WHERE user_id = $1 AND created_at > $2;
@@ -0,0 +1,21 @@
+-- name: CreateEmailChange :one
+INSERT INTO email_changes (token_hash, created_at, user_id, new_email, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: UseEmailChange :one
+UPDATE email_changes
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id, new_email;
+
+-- name: InvalidateEmailChanges :exec
+UPDATE email_changes
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
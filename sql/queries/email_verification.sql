@@ -0,0 +1,22 @@
+-- name: CreateEmailVerification :one
+INSERT INTO email_verifications (token_hash, created_at, user_id, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3
+)
+RETURNING *;
+
+-- name: GetLatestEmailVerification :one
+SELECT *
+FROM email_verifications
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: UseEmailVerification :one
+UPDATE email_verifications
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id;
@@ -0,0 +1,38 @@
+-- name: CreateIdentity :exec
+INSERT INTO identities (id, created_at, user_id, provider, subject, email)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+);
+
+-- name: GetUserByIdentity :one
+SELECT users.*
+FROM users
+JOIN identities ON identities.user_id = users.id
+WHERE identities.provider = $1 AND identities.subject = $2;
+
+-- name: CreateOAuthState :exec
+INSERT INTO oauth_states (state_hash, created_at, provider, code_verifier, nonce, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5
+);
+
+-- name: ConsumeOAuthState :one
+DELETE FROM oauth_states
+WHERE state_hash = $1 AND provider = $2 AND expires_at > NOW()
+RETURNING *;
+
+-- name: ListIdentitiesForUser :many
+SELECT *
+FROM identities
+WHERE user_id = $1
+ORDER BY created_at ASC;
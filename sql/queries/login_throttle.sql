@@ -0,0 +1,34 @@
+-- name: ClaimLoginAttempt :one
+-- counts an attempt before the password is checked; a locked key is left
+-- as it is
+INSERT INTO login_throttles (key, failures, last_failure_at)
+VALUES (
+    $1,
+    1,
+    NOW()
+)
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE
+        WHEN login_throttles.locked_until > NOW() THEN login_throttles.failures
+        WHEN login_throttles.last_failure_at < NOW() - INTERVAL '1 hour' THEN 1
+        ELSE login_throttles.failures + 1
+    END,
+    last_failure_at = CASE
+        WHEN login_throttles.locked_until > NOW() THEN login_throttles.last_failure_at
+        ELSE NOW()
+    END
+RETURNING *;
+
+-- name: ForgiveLoginAttempt :exec
+UPDATE login_throttles
+SET failures = GREATEST(failures - 1, 0)
+WHERE key = $1;
+
+-- name: SetLoginLockout :exec
+UPDATE login_throttles
+SET locked_until = $2
+WHERE key = $1;
+
+-- name: ClearLoginThrottle :exec
+DELETE FROM login_throttles
+WHERE key = $1;
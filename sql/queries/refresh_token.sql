@@ -10,12 +10,46 @@ VALUES (
 )
 RETURNING *;
 
+-- name: CreateClientRefreshToken :one
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, client_id, scope)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING *;
+
 -- name: GetUserFromRefreshToken :one
-SELECT user_id, revoked_at
+SELECT user_id, revoked_at, expires_at, client_id
+FROM refresh_tokens
+WHERE token = $1;
+
+-- name: GetRefreshToken :one
+SELECT *
 FROM refresh_tokens
 WHERE token = $1;
 
 -- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1;
\ No newline at end of file
+WHERE token = $1;
+
+-- name: RevokeAllRefreshTokensForUser :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeActiveRefreshToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE token = $1 AND revoked_at IS NULL;
+
+-- name: ListRefreshTokensForUser :many
+SELECT created_at, expires_at, revoked_at, client_id, scope
+FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at ASC;
@@ -0,0 +1,50 @@
+-- name: GetSubscription :one
+SELECT *
+FROM subscriptions
+WHERE user_id = $1;
+
+-- name: GetSubscriptionForUpdate :one
+-- locks the row until the transaction ends, so concurrent events and the
+-- expiry job apply one after another
+SELECT *
+FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE;
+
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (user_id, created_at, updated_at, plan, status, current_period_end)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4
+)
+ON CONFLICT (user_id) DO UPDATE
+SET updated_at = NOW(), plan = EXCLUDED.plan, status = EXCLUDED.status, current_period_end = EXCLUDED.current_period_end
+RETURNING *;
+
+-- name: ListLapsedSubscriptions :many
+SELECT *
+FROM subscriptions
+WHERE status <> 'expired' AND current_period_end < $1;
+
+-- name: CreateSubscriptionHistory :exec
+INSERT INTO subscription_history (id, created_at, user_id, event_type, plan, status, current_period_end, webhook_event_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- name: ListSubscriptionHistory :many
+SELECT *
+FROM subscription_history
+WHERE user_id = $1
+ORDER BY created_at ASC;
@@ -0,0 +1,36 @@
+-- name: GetUserProfile :one
+SELECT *
+FROM user_profiles
+WHERE user_id = $1;
+
+-- name: GetUserProfileByHandle :one
+SELECT *
+FROM user_profiles
+WHERE handle = $1;
+
+-- name: UpsertUserProfile :one
+INSERT INTO user_profiles (user_id, updated_at, handle, display_name, bio)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4
+)
+ON CONFLICT (user_id) DO UPDATE
+SET updated_at = NOW(), handle = EXCLUDED.handle, display_name = EXCLUDED.display_name, bio = EXCLUDED.bio
+RETURNING *;
+
+-- name: GetPublicProfile :one
+SELECT users.id, users.created_at, user_profiles.handle, user_profiles.display_name, user_profiles.bio,
+    (SELECT COUNT(*) FROM chirps WHERE chirps.user_id = users.id) AS chirp_count
+FROM users
+LEFT JOIN user_profiles ON user_profiles.user_id = users.id
+WHERE users.id = $1 AND users.deletion_requested_at IS NULL;
+
+-- name: GetPublicProfileByHandle :one
+SELECT users.id, users.created_at, user_profiles.handle, user_profiles.display_name, user_profiles.bio,
+    (SELECT COUNT(*) FROM chirps WHERE chirps.user_id = users.id) AS chirp_count
+FROM users
+LEFT JOIN user_profiles ON user_profiles.user_id = users.id
+WHERE user_profiles.handle = $1 AND users.deletion_requested_at IS NULL;
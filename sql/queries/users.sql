@@ -18,7 +18,56 @@ SELECT *
 FROM users
 WHERE email = $1;
 
--- name: UpdateEmailandPassword :exec
+-- name: ChangeEmail :exec
 UPDATE users
-SET email = $2, hashed_password = $3, updated_at = NOW()
-where id = $1;
+SET email = $2, email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetUserByID :one
+SELECT *
+FROM users
+WHERE id = $1;
+
+-- name: MarkEmailVerified :exec
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: UpdatePassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: RequestUserDeletion :one
+UPDATE users
+SET deletion_requested_at = NOW(), updated_at = NOW()
+WHERE id = $1
+RETURNING deletion_requested_at;
+
+-- name: CancelUserDeletion :exec
+UPDATE users
+SET deletion_requested_at = NULL, updated_at = NOW()
+WHERE id = $1;
+
+-- name: DeleteUsersPendingDeletion :execrows
+DELETE FROM users
+WHERE deletion_requested_at < $1;
+
+-- name: GetUserRole :one
+SELECT role
+FROM users
+WHERE id = $1;
+
+-- name: LockUser :exec
+-- serializes writes that check a per-user quota first, such as creating
+-- chirps under the hourly limit; NO KEY UPDATE leaves inserts that only
+-- reference the user unblocked
+SELECT id
+FROM users
+WHERE id = $1
+FOR NO KEY UPDATE;
+
+-- name: SetUserRole :execrows
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1;
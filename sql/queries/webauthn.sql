@@ -0,0 +1,41 @@
+-- name: CreateWebauthnCredential :exec
+INSERT INTO webauthn_credentials (id, created_at, updated_at, user_id, public_key, attestation_type, aaguid, sign_count, transports)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+);
+
+-- name: ListWebauthnCredentials :many
+SELECT *
+FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: UpdateWebauthnSignCount :exec
+UPDATE webauthn_credentials
+SET sign_count = $2, last_used_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: CreateWebauthnSession :one
+INSERT INTO webauthn_sessions (id, created_at, user_id, ceremony, session_data, expires_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING id;
+
+-- name: ConsumeWebauthnSession :one
+DELETE FROM webauthn_sessions
+WHERE id = $1 AND ceremony = $2 AND expires_at > NOW()
+RETURNING *;
@@ -0,0 +1,88 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, created_at, updated_at, user_id, url, secret, event_types)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetWebhookEndpoint :one
+SELECT *
+FROM webhook_endpoints
+WHERE id = $1;
+
+-- name: ListWebhookEndpoints :many
+SELECT *
+FROM webhook_endpoints
+WHERE user_id = $1 OR (sqlc.arg('include_global')::BOOLEAN AND user_id IS NULL)
+ORDER BY created_at ASC;
+
+-- name: DeleteWebhookEndpoint :exec
+DELETE FROM webhook_endpoints
+WHERE id = $1;
+
+-- name: ListEndpointsForEvent :many
+SELECT *
+FROM webhook_endpoints
+WHERE active
+    AND (user_id = $1 OR user_id IS NULL)
+    AND sqlc.arg('event_type')::TEXT = ANY(string_to_array(event_types, ' '));
+
+-- name: CreateWebhookDelivery :exec
+INSERT INTO webhook_deliveries (id, created_at, endpoint_id, event_type, payload, next_attempt_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    NOW()
+);
+
+-- name: ClaimWebhookDeliveries :many
+UPDATE webhook_deliveries
+SET next_attempt_at = sqlc.arg('lease_until')
+WHERE id IN (
+    SELECT id
+    FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at
+    LIMIT sqlc.arg('batch_size')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: FinishWebhookDeliveryAttempt :exec
+UPDATE webhook_deliveries
+SET status = $2, attempts = $3, next_attempt_at = $4, last_attempt_at = NOW(), last_status_code = $5, last_error = $6
+WHERE id = $1;
+
+-- name: CreateWebhookDeliveryAttempt :exec
+INSERT INTO webhook_delivery_attempts (id, created_at, delivery_id, status_code, error, duration_ms)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+);
+
+-- name: ListWebhookDeliveries :many
+SELECT *
+FROM webhook_deliveries
+WHERE endpoint_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: ListWebhookDeliveryAttempts :many
+SELECT webhook_delivery_attempts.*
+FROM webhook_delivery_attempts
+JOIN webhook_deliveries ON webhook_deliveries.id = webhook_delivery_attempts.delivery_id
+WHERE webhook_delivery_attempts.delivery_id = $1 AND webhook_deliveries.endpoint_id = $2
+ORDER BY webhook_delivery_attempts.created_at ASC;
@@ -0,0 +1,35 @@
+-- name: InsertWebhookEvent :one
+INSERT INTO webhook_events (id, source, event_id, event_type, payload, received_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW()
+)
+ON CONFLICT (source, event_id) DO NOTHING
+RETURNING *;
+
+-- name: GetWebhookEvent :one
+SELECT *
+FROM webhook_events
+WHERE id = $1;
+
+-- name: GetWebhookEventBySourceID :one
+SELECT *
+FROM webhook_events
+WHERE source = $1 AND event_id = $2;
+
+-- name: ListWebhookEvents :many
+SELECT *
+FROM webhook_events
+WHERE (sqlc.narg('outcome')::TEXT IS NULL OR outcome = sqlc.narg('outcome'))
+ORDER BY received_at DESC
+LIMIT $1;
+
+-- name: FinishWebhookEvent :one
+UPDATE webhook_events
+SET processed_at = NOW(), outcome = $2, error = $3, attempts = attempts + 1
+WHERE id = $1
+RETURNING *;
@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE webauthn_credentials (
+    id BYTEA PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    public_key BYTEA NOT NULL,
+    attestation_type TEXT NOT NULL,
+    aaguid BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL,
+    transports TEXT NOT NULL,
+    last_used_at TIMESTAMP
+);
+
+CREATE TABLE webauthn_sessions (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    ceremony TEXT NOT NULL,
+    session_data JSONB NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE webauthn_sessions;
+DROP TABLE webauthn_credentials;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE user_profiles (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    updated_at TIMESTAMP NOT NULL,
+    handle TEXT UNIQUE,
+    display_name TEXT NOT NULL DEFAULT '',
+    bio TEXT NOT NULL DEFAULT ''
+);
+
+-- +goose Down
+DROP TABLE user_profiles;
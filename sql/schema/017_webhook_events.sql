@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE webhook_events (
+    id UUID PRIMARY KEY,
+    source TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    received_at TIMESTAMP NOT NULL,
+    processed_at TIMESTAMP,
+    outcome TEXT NOT NULL DEFAULT 'pending',
+    error TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    UNIQUE (source, event_id)
+);
+
+CREATE INDEX webhook_events_received_at_idx ON webhook_events (received_at DESC);
+
+-- +goose Down
+DROP TABLE webhook_events;
@@ -0,0 +1,30 @@
+-- +goose Up
+CREATE TABLE subscriptions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('active', 'past_due', 'canceled', 'expired')),
+    current_period_end TIMESTAMP NOT NULL
+);
+
+CREATE TABLE subscription_history (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event_type TEXT NOT NULL,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL,
+    current_period_end TIMESTAMP NOT NULL,
+    webhook_event_id UUID REFERENCES webhook_events(id) ON DELETE SET NULL
+);
+
+-- existing Chirpy Red members get a subscription so they keep their badge
+INSERT INTO subscriptions (user_id, created_at, updated_at, plan, status, current_period_end)
+SELECT id, NOW(), NOW(), 'red', 'active', NOW() + INTERVAL '30 days'
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscription_history;
+DROP TABLE subscriptions;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- the newest TOTP time step each user has logged in with, so a code can't be
+-- used twice within its validity window
+CREATE TABLE totp_used_steps (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    step BIGINT NOT NULL,
+    used_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE totp_used_steps;
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/subscription"
+	"github.com/chichigami/chirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+var errInvalidSubscriptionEvent = errors.New("invalid subscription event")
+
+// applySubscriptionEvent moves a user's subscription through a billing
+// event, records it in the history and refreshes the is_chirpy_red column,
+// all in one transaction with any webhook the event triggers. The
+// subscription is read with a row lock so concurrent events can't apply to
+// the same starting state.
+func (cfg *apiConfig) applySubscriptionEvent(ctx context.Context, userID uuid.UUID, event subscription.Event, webhookEventID uuid.NullUUID) error {
+	if _, err := cfg.db.GetUserByID(ctx, userID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return cfg.inTx(ctx, func(q *database.Queries) error {
+		current, err := lockSubscription(ctx, q, userID)
+		if err != nil {
+			return err
+		}
+		next, err := current.Apply(event, now)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errInvalidSubscriptionEvent, err)
+		}
+		if err := saveSubscription(ctx, q, userID, next, event.Type, webhookEventID, now); err != nil {
+			return err
+		}
+		if event.Type != subscription.EventUpgraded {
+			return nil
+		}
+		return enqueueWebhookEvent(ctx, q, webhooks.EventUserUpgraded, userID, struct {
+			UserID           uuid.UUID `json:"user_id"`
+			Plan             string    `json:"plan"`
+			CurrentPeriodEnd time.Time `json:"current_period_end"`
+		}{userID, next.Plan, next.CurrentPeriodEnd})
+	})
+}
+
+// expireSubscriptions periodically expires subscriptions whose paid period
+// and grace period have run out, since no webhook announces that.
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cfg.expireLapsedSubscriptions(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) expireLapsedSubscriptions(ctx context.Context, now time.Time) {
+	lapsed, err := cfg.db.ListLapsedSubscriptions(ctx, now)
+	if err != nil {
+		slog.ErrorContext(ctx, "listing lapsed subscriptions failed", "error", err)
+	}
+	for _, dbSub := range lapsed {
+		// a renewal may have landed since the list was read, so decide
+		// again on the locked row
+		err := cfg.inTx(ctx, func(q *database.Queries) error {
+			current, err := lockSubscription(ctx, q, dbSub.UserID)
+			if err != nil {
+				return err
+			}
+			expired, changed := current.Expire(now)
+			if !changed {
+				return nil
+			}
+			return saveSubscription(ctx, q, dbSub.UserID, expired, "expired", uuid.NullUUID{}, now)
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "expiring subscription failed", "user_id", dbSub.UserID, "error", err)
+		}
+	}
+}
+
+// isChirpyRed reports whether userID has Chirpy Red right now. Responses
+// use it rather than the is_chirpy_red column, which only catches up when a
+// webhook arrives or expireSubscriptions runs.
+func (cfg *apiConfig) isChirpyRed(ctx context.Context, userID uuid.UUID) (bool, error) {
+	sub, err := cfg.loadSubscription(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return sub.Entitled(time.Now()), nil
+}
+
+func (cfg *apiConfig) loadSubscription(ctx context.Context, userID uuid.UUID) (subscription.Subscription, error) {
+	return subscriptionOrZero(cfg.db.GetSubscription(ctx, userID))
+}
+
+// lockSubscription loads userID's subscription and locks its row until q's
+// transaction ends.
+func lockSubscription(ctx context.Context, q *database.Queries, userID uuid.UUID) (subscription.Subscription, error) {
+	return subscriptionOrZero(q.GetSubscriptionForUpdate(ctx, userID))
+}
+
+// subscriptionOrZero treats a missing row as a user who never subscribed.
+func subscriptionOrZero(dbSub database.Subscription, err error) (subscription.Subscription, error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		return subscription.Subscription{}, nil
+	}
+	if err != nil {
+		return subscription.Subscription{}, err
+	}
+	return subscriptionFromDB(dbSub), nil
+}
+
+func saveSubscription(ctx context.Context, q *database.Queries, userID uuid.UUID, sub subscription.Subscription, eventType string, webhookEventID uuid.NullUUID, now time.Time) error {
+	_, err := q.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+		UserID:           userID,
+		Plan:             sub.Plan,
+		Status:           string(sub.Status),
+		CurrentPeriodEnd: sub.CurrentPeriodEnd,
+	})
+	if err != nil {
+		return err
+	}
+	err = q.CreateSubscriptionHistory(ctx, database.CreateSubscriptionHistoryParams{
+		UserID:           userID,
+		EventType:        eventType,
+		Plan:             sub.Plan,
+		Status:           string(sub.Status),
+		CurrentPeriodEnd: sub.CurrentPeriodEnd,
+		WebhookEventID:   webhookEventID,
+	})
+	if err != nil {
+		return err
+	}
+	return q.UpdateMembership(ctx, database.UpdateMembershipParams{
+		ID:          userID,
+		IsChirpyRed: sql.NullBool{Bool: sub.Entitled(now), Valid: true},
+	})
+}
+
+func subscriptionFromDB(dbSub database.Subscription) subscription.Subscription {
+	return subscription.Subscription{
+		Plan:             dbSub.Plan,
+		Status:           subscription.Status(dbSub.Status),
+		CurrentPeriodEnd: dbSub.CurrentPeriodEnd,
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/subscription"
+	"github.com/google/uuid"
+)
+
+func subscriptionRow(userID uuid.UUID, status subscription.Status, periodEnd time.Time) []any {
+	return []any{userID, time.Now(), time.Now(), subscription.DefaultPlan, string(status), periodEnd}
+}
+
+func TestApplySubscriptionEventLocksBeforeApplying(t *testing.T) {
+	userID := uuid.New()
+	db := newFakeDB(t)
+	db.returns("GetUserByID", userRow(database.User{ID: userID}))
+	db.returns("GetSubscriptionForUpdate", subscriptionRow(userID, subscription.StatusActive, time.Now().Add(time.Hour)))
+	db.returns("UpsertSubscription", subscriptionRow(userID, subscription.StatusCanceled, time.Now().Add(time.Hour)))
+	db.returns("CreateSubscriptionHistory")
+	db.returns("UpdateMembership")
+	cfg := db.config()
+
+	err := cfg.applySubscriptionEvent(context.Background(), userID, subscription.Event{Type: subscription.EventCanceled}, uuid.NullUUID{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"GetUserByID", "BEGIN", "GetSubscriptionForUpdate", "UpsertSubscription", "CreateSubscriptionHistory", "UpdateMembership", "COMMIT"}
+	if got := db.callNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("statements = %v, want %v", got, want)
+	}
+	if upserts := db.calledWith("UpsertSubscription"); upserts[0][2] != string(subscription.StatusCanceled) {
+		t.Errorf("saved status %v, want canceled", upserts[0][2])
+	}
+}
+
+func TestExpireLapsedSubscriptionsRechecksLockedRow(t *testing.T) {
+	now := time.Now()
+	renewedID := uuid.New()
+	lapsedID := uuid.New()
+	lapsedEnd := now.Add(-subscription.GracePeriod - time.Hour)
+	db := newFakeDB(t)
+	db.returns("ListLapsedSubscriptions",
+		subscriptionRow(renewedID, subscription.StatusActive, lapsedEnd),
+		subscriptionRow(lapsedID, subscription.StatusActive, lapsedEnd),
+	)
+	db.on("GetSubscriptionForUpdate", func(args []driver.Value) ([][]any, int64, error) {
+		// renewedID was renewed after the list was read
+		if args[0] == renewedID.String() {
+			return [][]any{subscriptionRow(renewedID, subscription.StatusActive, now.Add(subscription.Period))}, 1, nil
+		}
+		return [][]any{subscriptionRow(lapsedID, subscription.StatusActive, lapsedEnd)}, 1, nil
+	})
+	db.returns("UpsertSubscription", subscriptionRow(lapsedID, subscription.StatusExpired, lapsedEnd))
+	db.returns("CreateSubscriptionHistory")
+	db.returns("UpdateMembership")
+	cfg := db.config()
+
+	cfg.expireLapsedSubscriptions(context.Background(), now)
+
+	upserts := db.calledWith("UpsertSubscription")
+	if len(upserts) != 1 || upserts[0][0] != lapsedID.String() || upserts[0][2] != string(subscription.StatusExpired) {
+		t.Errorf("UpsertSubscription called with %v, want only %s expired", upserts, lapsedID)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+const (
+	webhookBatchSize = 20
+	// webhookLease is how long a claimed delivery stays hidden from other
+	// dispatchers. A crashed dispatcher's deliveries come back after it.
+	// A batch is sent one delivery after another, so the lease has to outlast
+	// every delivery in it timing out, plus time for the database writes.
+	webhookLease = webhookBatchSize*webhooks.Timeout + time.Minute
+)
+
+// enqueueWebhookEvent queues eventType for every active endpoint of owner
+// and every global endpoint subscribed to it. Callers pass queries bound to
+// the transaction that makes the change the event announces, so the event is
+// queued if and only if the change commits. Delivery happens later in
+// runWebhookDispatcher so the request that caused the event never waits on
+// a third party.
+func enqueueWebhookEvent(ctx context.Context, q *database.Queries, eventType string, owner uuid.UUID, data interface{}) error {
+	endpoints, err := q.ListEndpointsForEvent(ctx, database.ListEndpointsForEventParams{
+		UserID:    uuid.NullUUID{UUID: owner, Valid: true},
+		EventType: eventType,
+	})
+	if err != nil {
+		return fmt.Errorf("listing webhook endpoints for %s: %w", eventType, err)
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(struct {
+		ID        uuid.UUID   `json:"id"`
+		Type      string      `json:"type"`
+		CreatedAt time.Time   `json:"created_at"`
+		Data      interface{} `json:"data"`
+	}{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding %s webhook payload: %w", eventType, err)
+	}
+	for _, endpoint := range endpoints {
+		err := q.CreateWebhookDelivery(ctx, database.CreateWebhookDeliveryParams{
+			EndpointID: endpoint.ID,
+			EventType:  eventType,
+			Payload:    string(payload),
+		})
+		if err != nil {
+			return fmt.Errorf("queueing %s webhook for endpoint %s: %w", eventType, endpoint.ID, err)
+		}
+	}
+	return nil
+}
+
+// runWebhookDispatcher works through due deliveries, polling every interval
+// while the queue is empty.
+func (cfg *apiConfig) runWebhookDispatcher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deliveries, err := cfg.db.ClaimWebhookDeliveries(ctx, database.ClaimWebhookDeliveriesParams{
+			LeaseUntil: time.Now().Add(webhookLease),
+			BatchSize:  webhookBatchSize,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "claiming webhook deliveries failed", "error", err)
+		}
+		for _, delivery := range deliveries {
+			// deliveries left unsent come back once their lease runs out
+			if ctx.Err() != nil {
+				return
+			}
+			cfg.attemptWebhookDelivery(ctx, delivery)
+		}
+		if len(deliveries) == webhookBatchSize && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// attemptWebhookDelivery sends one delivery, logs the attempt and either
+// finishes it or schedules the next retry.
+func (cfg *apiConfig) attemptWebhookDelivery(ctx context.Context, delivery database.WebhookDelivery) {
+	endpoint, err := cfg.db.GetWebhookEndpoint(ctx, delivery.EndpointID)
+	if err != nil {
+		slog.ErrorContext(ctx, "fetching webhook endpoint failed", "delivery_id", delivery.ID, "error", err)
+		return
+	}
+
+	start := time.Now()
+	statusCode, deliverErr := cfg.webhookClient.Deliver(ctx, webhooks.Delivery{
+		ID:        delivery.ID.String(),
+		EventType: delivery.EventType,
+		URL:       endpoint.Url,
+		Secret:    endpoint.Secret,
+		Payload:   []byte(delivery.Payload),
+	})
+	// shutdown cut the delivery short, which says nothing about the
+	// endpoint; leave it to come back when the lease runs out
+	if ctx.Err() != nil {
+		return
+	}
+
+	status := sql.NullInt32{Int32: int32(statusCode), Valid: statusCode != 0}
+	errText := ""
+	if deliverErr != nil {
+		errText = deliverErr.Error()
+	}
+	err = cfg.db.CreateWebhookDeliveryAttempt(ctx, database.CreateWebhookDeliveryAttemptParams{
+		DeliveryID: delivery.ID,
+		StatusCode: status,
+		Error:      errText,
+		DurationMs: int32(time.Since(start).Milliseconds()),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "recording webhook delivery attempt failed", "delivery_id", delivery.ID, "error", err)
+	}
+
+	attempts := delivery.Attempts + 1
+	finish := database.FinishWebhookDeliveryAttemptParams{
+		ID:             delivery.ID,
+		Status:         "succeeded",
+		Attempts:       attempts,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		LastStatusCode: status,
+		LastError:      errText,
+	}
+	if deliverErr != nil {
+		if attempts >= webhooks.MaxAttempts {
+			finish.Status = "failed"
+		} else {
+			finish.Status = "pending"
+			finish.NextAttemptAt = time.Now().Add(webhooks.Backoff(int(attempts)))
+		}
+	}
+	cfg.metrics.webhookDelivered.WithLabelValues(finish.Status).Inc()
+	if err := cfg.db.FinishWebhookDeliveryAttempt(ctx, finish); err != nil {
+		slog.ErrorContext(ctx, "updating webhook delivery failed", "delivery_id", delivery.ID, "error", err)
+	}
+}
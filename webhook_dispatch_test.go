@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chichigami/chirpy/internal/database"
+	"github.com/chichigami/chirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+func TestAttemptWebhookDeliveryLeavesShutdownCancelledDeliveries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// shut down while the endpoint is still answering; the body has to
+		// be read for the server to notice the client going away
+		io.ReadAll(req.Body)
+		cancel()
+		<-req.Context().Done()
+	}))
+	defer endpoint.Close()
+
+	endpointID := uuid.New()
+	db := newFakeDB(t)
+	db.returns("GetWebhookEndpoint", []any{endpointID, time.Now(), time.Now(), nil, endpoint.URL, "secret", webhooks.EventChirpCreated, true})
+	cfg := db.config()
+	cfg.webhookClient = webhooks.NewClient(true)
+
+	cfg.attemptWebhookDelivery(ctx, database.WebhookDelivery{
+		ID:         uuid.New(),
+		EndpointID: endpointID,
+		EventType:  webhooks.EventChirpCreated,
+		Payload:    `{}`,
+	})
+
+	// the fake fails the test on any other statement, such as recording the
+	// attempt or finishing the delivery
+	if got := db.callNames(); len(got) != 1 {
+		t.Errorf("statements = %v, want only the endpoint lookup", got)
+	}
+}